@@ -1,39 +1,24 @@
 package main
 
 import (
-	"context"
-	"flag"
-	"log"
+	"os"
 
-	"github.com/WqyJh/vaultsync/syncer"
-)
-
-var (
-	localPath  = flag.String("local-path", "", "path of the local files")
-	vaultPath  = flag.String("vault-path", "", "path of the vault files")
-	vaultAddr  = flag.String("vault-addr", "", "vault address")
-	vaultToken = flag.String("vault-token", "", "vault token")
-	roleId     = flag.String("role-id", "", "role id")
-	secretId   = flag.String("secret-id", "", "secret id")
-	mountPath  = flag.String("mount-path", "", "mount path")
-	casTry     = flag.Int("cas-try", 3, "number of times to try cas")
+	"github.com/WqyJh/vaultsync/internal/cli"
 )
 
+// Usage:
+//
+//	vaultsync [push] [flags]          push local path to vault
+//	vaultsync pull [flags]            pull vault path to local path
+//	vaultsync diff [flags]            print the changed fields of each key
+//	vaultsync plan [flags]            print the changes, save them with -out
+//	vaultsync apply [flags] plan.json apply a plan saved by plan
+//	vaultsync validate [flags]        check the local files without talking to vault
+//	vaultsync history [flags] key     print the versions of a key
+//	vaultsync rollback [flags] key version
+//	vaultsync adopt [flags] [key...]  mark keys as managed by -managed-source
+//
+// Run vaultsync -h for the flags.
 func main() {
-	flag.Parse()
-
-	syncer := syncer.NewSyncer(syncer.SyncerConfig{
-		VaultAddr:     *vaultAddr,
-		VaultToken:    *vaultToken,
-		MountPath:     *mountPath,
-		VaultPath:     *vaultPath,
-		LocalPath:     *localPath,
-		CasTry:        *casTry,
-		VaultRoleId:   *roleId,
-		VaultSecretId: *secretId,
-	})
-	err := syncer.Sync(context.Background())
-	if err != nil {
-		log.Fatalf("failed to sync: %+v", err)
-	}
+	cli.Main(os.Args[1:])
 }
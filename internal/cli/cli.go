@@ -0,0 +1,212 @@
+// Package cli is the command line of vaultsync, shared by the vaultsync and
+// vaultfetch binaries.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/WqyJh/vaultsync/syncer"
+)
+
+var flags = flag.NewFlagSet("vaultsync", flag.ExitOnError)
+
+var (
+	localPath     = flags.String("local-path", "", "path of the local files")
+	vaultPath     = flags.String("vault-path", "", "path of the vault files")
+	vaultAddr     = flags.String("vault-addr", "", "vault address")
+	vaultToken    = flags.String("vault-token", "", "vault token")
+	roleId        = flags.String("role-id", "", "role id")
+	secretId      = flags.String("secret-id", "", "secret id")
+	k8sRole       = flags.String("kubernetes-role", "", "role to login with the kubernetes auth method if there is no token")
+	k8sJWTPath    = flags.String("kubernetes-jwt-path", syncer.DefaultKubernetesJWTPath, "service account token to login with the kubernetes auth method")
+	k8sMount      = flags.String("kubernetes-mount", "kubernetes", "mount of the kubernetes auth method")
+	mountPath     = flags.String("mount-path", "", "mount path")
+	casTry        = flags.Int("cas-try", 3, "number of times to try cas")
+	concurrency   = flags.Int("concurrency", 1, "number of keys to read and write at a time")
+	kvVersion     = flags.Int("kv-version", 0, "version of the kv secrets engine, 1 or 2, detected from the mount if 0")
+	configFile    = flags.String("config", "", "vaultsync.yaml file of mappings to push or plan, the vault flags override its settings")
+	include       stringsFlag
+	exclude       stringsFlag
+	ageKeyFile    = flags.String("sops-age-key-file", "", "file of age identities to decrypt sops files with, defaults to SOPS_AGE_KEY_FILE")
+	pgpSecRing    = flags.String("sops-pgp-secring", "", "pgp secret keyring to decrypt sops files with, gpg is used if empty")
+	ageIdentity   = flags.String("age-identity", "", "file of age identities to decrypt .json.age files with")
+	dryRun        = flags.Bool("dry-run", false, "print the changes without writing to vault, used by push")
+	watch         = flags.Bool("watch", false, "keep running and push the keys of changed files, used by push")
+	debounce      = flags.Duration("debounce", 500*time.Millisecond, "time without changes to wait for before pushing, used with -watch")
+	deletePolicy  = flags.String("delete-policy", "purge", "what to do with keys whose local files are gone, purge, soft or retain")
+	maxDeletes    = flags.Int("max-deletes", 0, "abort if more keys would be deleted, 0 for no limit")
+	maxDelPct     = flags.Float64("max-delete-percent", syncer.DefaultMaxDeletePercent, "abort if a larger percentage of the keys in vault would be deleted, 0 for no limit")
+	forceDelete   = flags.Bool("force-delete", false, "delete keys even if -max-deletes or -max-delete-percent is exceeded")
+	managedSrc    = flags.String("managed-source", "", "mark written keys as managed by this source and only delete keys with the mark")
+	out           = flags.String("out", "", "file to save the plan to, used by plan")
+	reveal        = flags.Bool("reveal", false, "print secret values, used by diff")
+	format        = flags.String("format", "json", "format of the pulled files, json, yaml, env, toml or json.age")
+	encryptSops   = flags.String("encrypt-sops", "", "comma separated age recipients and pgp fingerprints to encrypt the pulled json or yaml files for with sops")
+	ageRecipients = flags.String("age-recipients", "", "comma separated age recipients to encrypt the pulled files for, used by -format json.age")
+	interval      = flags.Duration("interval", 0, "keep running and pull every interval, e.g. 1m, removing the files of deleted keys")
+	reloadCommand = flags.String("reload-command", "", "command to run with sh -c when pulled files changed, the changed keys are in VAULTFETCH_CHANGED_KEYS")
+	reloadPidFile = flags.String("reload-pidfile", "", "pid file of a process to signal when pulled files changed")
+	reloadSignal  = flags.String("reload-signal", "HUP", "signal to send to the process in -reload-pidfile")
+	reloadTouch   = flags.String("reload-touch", "", "file to touch when pulled files changed")
+)
+
+func init() {
+	flags.Var(&include, "include", "glob of keys relative to -vault-path to include, ** matches any number of directories, can be repeated")
+	flags.Var(&exclude, "exclude", "glob of keys relative to -vault-path to exclude, can be repeated")
+	flags.Usage = usage
+}
+
+// commands by name, sync is the old name of push
+var commands = map[string]func(args []string){
+	"push":     runPush,
+	"sync":     runPush,
+	"pull":     runPull,
+	"diff":     runDiff,
+	"plan":     runPlan,
+	"apply":    runApply,
+	"validate": runValidate,
+	"history":  runHistory,
+	"rollback": runRollback,
+	"adopt":    runAdopt,
+}
+
+// configCommands are the commands that accept -config
+var configCommands = map[string]bool{
+	"push": true,
+	"sync": true,
+	"plan": true,
+}
+
+func usage() {
+	fmt.Fprint(flags.Output(), `Usage:
+
+	vaultsync [push] [flags]              push local path to vault
+	vaultsync push -watch [flags]         push, then push changed files until interrupted
+	vaultsync push -config vaultsync.yaml push the mappings in the config file
+	vaultsync pull [flags]                pull vault path to local path
+	vaultsync diff [flags]                print the changed fields of each key
+	vaultsync plan [flags]                print the changes, save them with -out
+	vaultsync apply [flags] plan.json     apply a plan saved by plan
+	vaultsync validate [flags]            check the local files without talking to vault
+	vaultsync history [flags] key         print the versions of a key
+	vaultsync rollback [flags] key version
+	                                      write an earlier version of a key as its current version
+	vaultsync adopt [flags] [key...]      mark keys with local files, or the given keys, as managed
+
+Flags:
+
+`)
+	flags.PrintDefaults()
+}
+
+// Main runs the command in args, push if args start with a flag.
+func Main(args []string) {
+	command := "push"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
+	run, ok := commands[command]
+	if !ok {
+		log.Printf("unknown command: %s", command)
+		flags.Usage()
+		os.Exit(2)
+	}
+	_ = flags.Parse(args)
+	if *configFile != "" && !configCommands[command] {
+		log.Fatalf("-config is not supported by %s, only by push and plan", command)
+	}
+
+	var recipients []string
+	if *encryptSops != "" {
+		for _, recipient := range strings.Split(*encryptSops, ",") {
+			recipients = append(recipients, strings.TrimSpace(recipient))
+		}
+	}
+	var ageRecipientList []string
+	if *ageRecipients != "" {
+		ageRecipientList = strings.Split(*ageRecipients, ",")
+	}
+	syncer.RegisterCodec(syncer.SOPSCodec{Codec: syncer.JSONCodec{}, AgeKeyFile: *ageKeyFile, PGPSecRing: *pgpSecRing, Recipients: recipients})
+	syncer.RegisterCodec(syncer.SOPSCodec{Codec: syncer.YAMLCodec{}, AgeKeyFile: *ageKeyFile, PGPSecRing: *pgpSecRing, Recipients: recipients})
+	syncer.RegisterCodec(syncer.AgeCodec{Codec: syncer.JSONCodec{}, IdentityFile: *ageIdentity, Recipients: ageRecipientList})
+
+	run(flags.Args())
+}
+
+// syncerConfig returns the SyncerConfig of the flags.
+func syncerConfig() syncer.SyncerConfig {
+	return syncer.SyncerConfig{
+		VaultAddr:         *vaultAddr,
+		VaultToken:        *vaultToken,
+		MountPath:         *mountPath,
+		VaultPath:         *vaultPath,
+		LocalPath:         *localPath,
+		CasTry:            *casTry,
+		VaultRoleId:       *roleId,
+		VaultSecretId:     *secretId,
+		KubernetesRole:    *k8sRole,
+		KubernetesJWTPath: *k8sJWTPath,
+		KubernetesMount:   *k8sMount,
+		Concurrency:       *concurrency,
+		KVVersion:         *kvVersion,
+		Format:            *format,
+		DeletePolicy:      syncer.DeletePolicy(*deletePolicy),
+		MaxDeletes:        *maxDeletes,
+		MaxDeletePercent:  *maxDelPct,
+		ForceDelete:       *forceDelete,
+		ManagedSource:     *managedSrc,
+		Include:           include,
+		Exclude:           exclude,
+	}
+}
+
+// loadConfig reads -config, with the vault flags that were given overriding
+// its settings. -force-delete forces the deletes of all mappings.
+func loadConfig() *syncer.Config {
+	config, err := syncer.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("failed to load config: %+v", err)
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "vault-addr":
+			config.VaultAddr = *vaultAddr
+		case "vault-token":
+			config.VaultToken = *vaultToken
+		case "role-id":
+			config.VaultRoleId = *roleId
+		case "secret-id":
+			config.VaultSecretId = *secretId
+		case "kubernetes-role":
+			config.KubernetesRole = *k8sRole
+		case "kubernetes-jwt-path":
+			config.KubernetesJWTPath = *k8sJWTPath
+		case "kubernetes-mount":
+			config.KubernetesMount = *k8sMount
+		}
+	})
+	if *forceDelete {
+		for i := range config.Mappings {
+			config.Mappings[i].ForceDelete = true
+		}
+	}
+	return config
+}
+
+// stringsFlag is a flag that can be given more than once.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
@@ -0,0 +1,218 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/WqyJh/vaultsync/syncer"
+)
+
+func runPush(args []string) {
+	if *configFile != "" {
+		if *watch {
+			log.Fatalf("-watch is not supported with -config")
+		}
+		runConfig(*dryRun)
+		return
+	}
+	if *dryRun {
+		plan("")
+		return
+	}
+	s := syncer.NewSyncer(syncerConfig())
+	if *watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err := s.Watch(ctx, *debounce)
+		if err != nil {
+			log.Fatalf("failed to watch: %+v", err)
+		}
+		return
+	}
+	err := s.Sync(context.Background())
+	if err != nil {
+		log.Fatalf("failed to sync: %+v", err)
+	}
+}
+
+func runConfig(dryRun bool) {
+	results, err := syncer.SyncConfig(context.Background(), loadConfig(), dryRun)
+	if dryRun {
+		for _, result := range results {
+			for _, action := range result.Actions {
+				fmt.Println(action)
+			}
+		}
+	}
+	if printErr := syncer.PrintReport(os.Stdout, results); printErr != nil {
+		log.Fatalf("failed to print report: %+v", printErr)
+	}
+	if err != nil {
+		log.Fatalf("failed to sync: %+v", err)
+	}
+}
+
+func runPull(args []string) {
+	if *encryptSops != "" && *format != "json" && *format != "yaml" {
+		log.Fatalf("-encrypt-sops requires -format json or yaml")
+	}
+	fetcher := syncer.NewFetcher(syncerConfig())
+	if *reloadCommand != "" || *reloadPidFile != "" || *reloadTouch != "" {
+		sig, err := syncer.ParseSignal(*reloadSignal)
+		if err != nil {
+			log.Fatalf("invalid -reload-signal: %+v", err)
+		}
+		hook := &syncer.ReloadHook{
+			Command:   *reloadCommand,
+			PidFile:   *reloadPidFile,
+			Signal:    sig,
+			TouchFile: *reloadTouch,
+		}
+		fetcher.OnChange = hook.Notify
+	}
+
+	if *interval > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err := fetcher.Run(ctx, *interval)
+		if err != nil {
+			log.Fatalf("failed to run: %+v", err)
+		}
+		return
+	}
+	err := fetcher.Fetch(context.Background())
+	if err != nil {
+		log.Fatalf("failed to fetch: %+v", err)
+	}
+}
+
+func runPlan(args []string) {
+	if *configFile != "" {
+		if *out != "" {
+			log.Fatalf("-out is not supported with -config")
+		}
+		runConfig(true)
+		return
+	}
+	plan(*out)
+}
+
+func plan(out string) {
+	s := syncer.NewSyncer(syncerConfig())
+	actions, err := s.Plan(context.Background())
+	if err != nil {
+		log.Fatalf("failed to plan: %+v", err)
+	}
+	if len(actions) == 0 {
+		fmt.Println("no changes")
+	}
+	for _, action := range actions {
+		fmt.Println(action)
+	}
+
+	if out == "" {
+		return
+	}
+	err = syncer.WritePlan(out, &syncer.SavedPlan{
+		MountPath: s.MountPath,
+		VaultPath: s.VaultPath,
+		Actions:   actions,
+	})
+	if err != nil {
+		log.Fatalf("failed to save plan: %+v", err)
+	}
+	log.Printf("plan saved to %s", out)
+}
+
+func runApply(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: vaultsync apply [flags] plan.json")
+	}
+	plan, err := syncer.ReadPlan(args[0])
+	if err != nil {
+		log.Fatalf("failed to read plan: %+v", err)
+	}
+	config := syncerConfig()
+	config.MountPath = plan.MountPath
+	config.VaultPath = plan.VaultPath
+
+	s := syncer.NewSyncer(config)
+	err = s.Apply(context.Background(), plan.Actions)
+	if err != nil {
+		log.Fatalf("failed to apply: %+v", err)
+	}
+}
+
+func runDiff(args []string) {
+	s := syncer.NewSyncer(syncerConfig())
+	diffs, err := s.Diff(context.Background())
+	if err != nil {
+		log.Fatalf("failed to diff: %+v", err)
+	}
+	if len(diffs) == 0 {
+		fmt.Println("no changes")
+		return
+	}
+	err = syncer.PrintDiff(os.Stdout, diffs, *reveal)
+	if err != nil {
+		log.Fatalf("failed to print diff: %+v", err)
+	}
+}
+
+func runValidate(args []string) {
+	s := syncer.NewSyncer(syncerConfig())
+	keys, err := s.Validate()
+	if err != nil {
+		log.Fatalf("invalid local files:\n%v", err)
+	}
+	fmt.Printf("%d keys ok\n", len(keys))
+}
+
+func runHistory(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: vaultsync history [flags] key")
+	}
+	s := syncer.NewSyncer(syncerConfig())
+	versions, err := s.History(context.Background(), args[0])
+	if err != nil {
+		log.Fatalf("failed to read history: %+v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tCREATED\tDELETED\tDESTROYED")
+	for _, v := range versions {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%t\n", v.Version, v.CreatedTime, v.DeletionTime, v.Destroyed)
+	}
+	err = w.Flush()
+	if err != nil {
+		log.Fatalf("failed to print history: %+v", err)
+	}
+}
+
+func runRollback(args []string) {
+	if len(args) != 2 {
+		log.Fatalf("usage: vaultsync rollback [flags] key version")
+	}
+	version, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		log.Fatalf("invalid version: %s", args[1])
+	}
+	s := syncer.NewSyncer(syncerConfig())
+	_, err = s.Rollback(context.Background(), args[0], version)
+	if err != nil {
+		log.Fatalf("failed to rollback: %+v", err)
+	}
+}
+
+func runAdopt(args []string) {
+	s := syncer.NewSyncer(syncerConfig())
+	err := s.Adopt(context.Background(), args...)
+	if err != nil {
+		log.Fatalf("failed to adopt: %+v", err)
+	}
+}
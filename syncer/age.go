@@ -0,0 +1,120 @@
+package syncer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+// AgeCodec reads and writes secret files of another codec encrypted with age,
+// e.g. config.json.age. Its extensions are those of Codec followed by ".age".
+//
+// Metadata is not secret, the metadata file of config.json.age is the plain
+// config.meta.json.
+type AgeCodec struct {
+	// Codec is the codec of the decrypted files.
+	Codec Codec
+	// IdentityFile is the path of a file of age identities to decrypt with.
+	IdentityFile string
+	// Recipients are the age recipients, "age1...", Encode encrypts secret
+	// data for.
+	Recipients []string
+}
+
+func (c AgeCodec) Extensions() []string {
+	var exts []string
+	for _, ext := range c.Codec.Extensions() {
+		exts = append(exts, ext+".age")
+	}
+	return exts
+}
+
+// MetadataExtension returns ext without ".age", metadata files are not
+// encrypted.
+func (c AgeCodec) MetadataExtension(ext string) string {
+	return strings.TrimSuffix(ext, ".age")
+}
+
+func (c AgeCodec) DecodeData(b []byte) (map[string]interface{}, error) {
+	b, err := c.decrypt(b)
+	if err != nil {
+		return nil, err
+	}
+	return c.Codec.DecodeData(b)
+}
+
+func (c AgeCodec) DecodeMetadata(b []byte) (*schema.KvV2WriteMetadataRequest, error) {
+	return c.Codec.DecodeMetadata(b)
+}
+
+func (c AgeCodec) Encode(v interface{}) ([]byte, error) {
+	b, err := c.Codec.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		return b, nil
+	}
+	return c.encrypt(b)
+}
+
+// decrypt decrypts b, which may be armored.
+func (c AgeCodec) decrypt(b []byte) ([]byte, error) {
+	if c.IdentityFile == "" {
+		return nil, fmt.Errorf("no age identity file to decrypt with")
+	}
+	f, err := os.Open(c.IdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open age identity file: %s, %w", c.IdentityFile, err)
+	}
+	defer f.Close()
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse age identity file: %s, %w", c.IdentityFile, err)
+	}
+
+	var src io.Reader = bytes.NewReader(b)
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte(armor.Header)) {
+		src = armor.NewReader(src)
+	}
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt age file: %w", err)
+	}
+	return io.ReadAll(r)
+}
+
+func (c AgeCodec) encrypt(b []byte) ([]byte, error) {
+	if len(c.Recipients) == 0 {
+		return nil, fmt.Errorf("no age recipients to encrypt for")
+	}
+	var recipients []age.Recipient
+	for _, s := range c.Recipients {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient: %s, %w", s, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
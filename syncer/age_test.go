@@ -0,0 +1,94 @@
+package syncer_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/stretchr/testify/require"
+)
+
+const ageRecipient = "age1mg67p7qnvmz4a5f8t4qphy7096lksax50hd5qs6epltpaexflszs7552hu"
+
+func TestAge(t *testing.T) {
+	ctx := context.Background()
+	localPath := "../testdata/dir9"
+
+	_, err := syncer.LoadDesiredState(localPath, "unittest")
+	require.ErrorContains(t, err, "no age identity file")
+
+	syncer.RegisterCodec(syncer.AgeCodec{
+		Codec:        syncer.JSONCodec{},
+		IdentityFile: "../testdata/age/key.txt",
+		Recipients:   []string{ageRecipient},
+	})
+	t.Cleanup(func() {
+		syncer.RegisterCodec(syncer.AgeCodec{Codec: syncer.JSONCodec{}})
+	})
+
+	backend := newMemBackend()
+	sync := syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: localPath,
+		CasTry:    3,
+	}, backend)
+	err = sync.Sync(ctx)
+	require.NoError(t, err)
+
+	data, err := backend.ReadData(ctx, "unittest/app")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"username": "admin", "password": "s3cret"}, data)
+	metadata, err := backend.ReadMetadata(ctx, "unittest/app")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), metadata.MaxVersions)
+	require.Equal(t, map[string]interface{}{"owner": "unittest"}, metadata.CustomMetadata)
+	data, err = backend.ReadData(ctx, "unittest/sub1/api")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"token": "abc123"}, data)
+
+	actions, err := sync.Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+
+	fetchPath := t.TempDir()
+	err = syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: fetchPath,
+		Format:    "json.age",
+	}, backend).Fetch(ctx)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(fetchPath, "app.json.age"))
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "s3cret")
+	fileEqual(t, filepath.Join(localPath, "app.json.age"), filepath.Join(fetchPath, "app.json.age"), false)
+	fileEqual(t, filepath.Join(localPath, "app.meta.json"), filepath.Join(fetchPath, "app.meta.json"), true)
+	fileEqual(t, filepath.Join(localPath, "sub1/api.json.age"), filepath.Join(fetchPath, "sub1/api.json.age"), false)
+
+	// without an identity the fetched files can not be decrypted, but are
+	// still not rewritten while vault is unchanged
+	syncer.RegisterCodec(syncer.AgeCodec{
+		Codec:      syncer.JSONCodec{},
+		Recipients: []string{ageRecipient},
+	})
+	fetcher := syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: t.TempDir(),
+		Format:    "json.age",
+	}, backend)
+	changed, err := fetcher.Refresh(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"unittest/app", "unittest/sub1/api"}, changed)
+	for i := 0; i < 2; i++ {
+		changed, err = fetcher.Refresh(ctx)
+		require.NoError(t, err)
+		require.Empty(t, changed)
+	}
+	_, err = backend.WriteData(ctx, "unittest/app", map[string]interface{}{"username": "admin"}, 1)
+	require.NoError(t, err)
+	changed, err = fetcher.Refresh(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"unittest/app"}, changed)
+}
@@ -0,0 +1,179 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+// DefaultKubernetesJWTPath is where Kubernetes projects the service account
+// token into pods.
+const DefaultKubernetesJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultAuth is a vault client and its login. A token got by logging in with the
+// Kubernetes role or app role of the config is renewed before its lease runs
+// out, and replaced by logging in again if it can not be renewed or vault
+// rejects it. A VaultToken given in the config is used as is.
+type vaultAuth struct {
+	client *vault.Client
+	config SyncerConfig
+	// login is whether the token is got by logging in
+	login bool
+
+	mu    sync.Mutex
+	token string
+	// renewAt is when the token is renewed, zero if its lease never runs out
+	renewAt   time.Time
+	renewable bool
+}
+
+// newVaultAuth creates a client of the vault configured by config and logs in
+// if config has no VaultToken, which is then set to the token of the login.
+func newVaultAuth(ctx context.Context, config *SyncerConfig) (*vaultAuth, error) {
+	client, err := vault.New(
+		vault.WithAddress(config.VaultAddr),
+		vault.WithRequestTimeout(30*time.Second),
+		vault.WithEnvironment(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault client: %w", err)
+	}
+	a := &vaultAuth{
+		client: client,
+		config: *config,
+		login:  config.VaultToken == "",
+	}
+	if a.login {
+		err = a.relogin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		config.VaultToken = a.token
+		return a, nil
+	}
+	err = client.SetToken(config.VaultToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set vault token: %w", err)
+	}
+	return a, nil
+}
+
+// relogin logs in with the Kubernetes role if set, the app role otherwise, and
+// uses the new token. The caller holds mu, or is the only user of a.
+func (a *vaultAuth) relogin(ctx context.Context) error {
+	var auth *vault.ResponseAuth
+	var err error
+	if a.config.KubernetesRole != "" {
+		auth, err = kubernetesLogin(ctx, a.client, &a.config)
+	} else {
+		var response *vault.Response[map[string]interface{}]
+		response, err = a.client.Auth.AppRoleLogin(ctx, schema.AppRoleLoginRequest{
+			RoleId:   a.config.VaultRoleId,
+			SecretId: a.config.VaultSecretId,
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to login with app role: %w", err)
+		} else {
+			auth = response.Auth
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if auth == nil {
+		return fmt.Errorf("failed to login: no token in response")
+	}
+
+	err = a.client.SetToken(auth.ClientToken)
+	if err != nil {
+		return fmt.Errorf("failed to set vault token: %w", err)
+	}
+	a.token = auth.ClientToken
+	a.schedule(auth)
+	return nil
+}
+
+// schedule sets when to renew the token of auth, after two thirds of its
+// lease.
+func (a *vaultAuth) schedule(auth *vault.ResponseAuth) {
+	a.renewable = auth.Renewable
+	a.renewAt = time.Time{}
+	if auth.LeaseDuration > 0 {
+		a.renewAt = time.Now().Add(time.Duration(auth.LeaseDuration) * time.Second * 2 / 3)
+	}
+}
+
+// refresh renews the token if it is due, or logs in again if it can not be
+// renewed.
+func (a *vaultAuth) refresh(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.login || a.renewAt.IsZero() || time.Now().Before(a.renewAt) {
+		return nil
+	}
+	if a.renewable {
+		response, err := a.client.Auth.TokenRenewSelf(ctx, schema.TokenRenewSelfRequest{})
+		if err == nil && response.Auth != nil {
+			a.schedule(response.Auth)
+			return nil
+		}
+		log.Printf("failed to renew vault token, login again: %+v", err)
+	}
+	return a.relogin(ctx)
+}
+
+// do calls fn after refreshing the token. If vault rejected the token, fn is
+// called again after logging in again. A nil vaultAuth only calls fn.
+func (a *vaultAuth) do(ctx context.Context, fn func() error) error {
+	if a == nil {
+		return fn()
+	}
+	err := a.refresh(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn()
+	if !a.login || !vault.IsErrorStatus(err, http.StatusForbidden) {
+		return err
+	}
+	log.Printf("vault token rejected, login again: %+v", err)
+	a.mu.Lock()
+	err = a.relogin(ctx)
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return fn()
+}
+
+// kubernetesLogin logs in with the Kubernetes auth method.
+func kubernetesLogin(ctx context.Context, client *vault.Client, config *SyncerConfig) (*vault.ResponseAuth, error) {
+	jwtPath := config.KubernetesJWTPath
+	if jwtPath == "" {
+		jwtPath = DefaultKubernetesJWTPath
+	}
+	mount := config.KubernetesMount
+	if mount == "" {
+		mount = "kubernetes"
+	}
+	jwt, err := os.ReadFile(jwtPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %s, %w", jwtPath, err)
+	}
+	response, err := client.Auth.KubernetesLogin(ctx, schema.KubernetesLoginRequest{
+		Jwt:  strings.TrimSpace(string(jwt)),
+		Role: config.KubernetesRole,
+	}, vault.WithMountPath(mount))
+	if err != nil {
+		return nil, fmt.Errorf("failed to login with kubernetes: %s, %w", config.KubernetesRole, err)
+	}
+	return response.Auth, nil
+}
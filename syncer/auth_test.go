@@ -0,0 +1,105 @@
+package syncer_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/WqyJh/vaultsync/syncer/vaulttest"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenRenewal(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{
+		AppRoles: []string{"unittest"},
+		TokenTTL: time.Second,
+	})
+	defer vaultServer.Stop()
+	appRole := vaultServer.AppRoleTokens["unittest"]
+
+	err := syncer.NewSyncer(syncer.SyncerConfig{
+		VaultAddr:  vaultServer.VaultAddr,
+		VaultToken: vaultServer.RootToken,
+		MountPath:  "kv",
+		VaultPath:  "unittest",
+		LocalPath:  "../testdata/dir1",
+	}).Sync(ctx)
+	require.NoError(t, err)
+
+	fetcher := syncer.NewFetcher(syncer.SyncerConfig{
+		VaultAddr:     vaultServer.VaultAddr,
+		VaultRoleId:   appRole.RoleId,
+		VaultSecretId: appRole.SecretId,
+		MountPath:     "kv",
+		VaultPath:     "unittest",
+		LocalPath:     t.TempDir(),
+	})
+	changed, err := fetcher.Refresh(ctx)
+	require.NoError(t, err)
+	require.Len(t, changed, 3)
+	token := fetcher.VaultToken
+	require.NotEmpty(t, token)
+
+	// the token is renewed after two thirds of its lease, so it outlives it
+	for i := 0; i < 3; i++ {
+		time.Sleep(700 * time.Millisecond)
+		_, err = fetcher.Refresh(ctx)
+		require.NoError(t, err)
+	}
+	client, err := vault.New(vault.WithAddress(vaultServer.VaultAddr))
+	require.NoError(t, err)
+	err = client.SetToken(token)
+	require.NoError(t, err)
+	_, err = client.Secrets.KvV2Read(ctx, "unittest/config_1", vault.WithMountPath("kv"))
+	require.NoError(t, err)
+
+	// a rejected token is replaced by logging in again
+	vaultServer.ExpireTokens()
+	_, err = client.Secrets.KvV2Read(ctx, "unittest/config_1", vault.WithMountPath("kv"))
+	require.True(t, vault.IsErrorStatus(err, 403))
+	_, err = fetcher.Refresh(ctx)
+	require.NoError(t, err)
+}
+
+func TestKubernetesLogin(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{
+		KubernetesRoles: map[string]string{"unittest": "service-account-jwt"},
+		KubernetesMount: "k8s",
+	})
+	defer vaultServer.Stop()
+
+	jwtPath := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(jwtPath, []byte("service-account-jwt\n"), 0600)
+	require.NoError(t, err)
+	newSyncer := func(role, jwtPath string) *syncer.Syncer {
+		return syncer.NewSyncer(syncer.SyncerConfig{
+			VaultAddr:         vaultServer.VaultAddr,
+			KubernetesRole:    role,
+			KubernetesJWTPath: jwtPath,
+			KubernetesMount:   "k8s",
+			MountPath:         "kv",
+			VaultPath:         "unittest",
+			LocalPath:         "../testdata/dir1",
+			CasTry:            3,
+		})
+	}
+	err = newSyncer("unittest", jwtPath).Sync(ctx)
+	require.NoError(t, err)
+
+	err = newSyncer("other", jwtPath).Sync(ctx)
+	require.True(t, vault.IsErrorStatus(err, 400))
+
+	err = os.WriteFile(jwtPath, []byte("wrong"), 0600)
+	require.NoError(t, err)
+	err = newSyncer("unittest", jwtPath).Sync(ctx)
+	require.True(t, vault.IsErrorStatus(err, 403))
+
+	err = newSyncer("unittest", filepath.Join(t.TempDir(), "missing")).Sync(ctx)
+	require.ErrorContains(t, err, "failed to read service account token")
+}
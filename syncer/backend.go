@@ -0,0 +1,276 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+// ErrNotFound is wrapped by the errors a KVBackend returns for a key or
+// directory that does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrMetadataUnsupported is returned by backends that do not store metadata
+// when asked to write it.
+var ErrMetadataUnsupported = errors.New("metadata is not supported")
+
+// KVBackend is the key value store secrets are synced to and fetched from.
+type KVBackend interface {
+	// List returns the names directly under dir, directories end with "/".
+	List(ctx context.Context, dir string) ([]string, error)
+	// ReadData returns the data of the current version of key.
+	ReadData(ctx context.Context, key string) (map[string]interface{}, error)
+	// ReadMetadata returns the metadata of key, which exists as long as any
+	// version of key exists, even if the current version is deleted.
+	ReadMetadata(ctx context.Context, key string) (*schema.KvV2ReadMetadataResponse, error)
+	// WriteData writes a new version of key if its current version is cas, 0
+	// if key does not exist, and returns the new version.
+	WriteData(ctx context.Context, key string, data map[string]interface{}, cas int64) (int64, error)
+	// WriteMetadata replaces the metadata of key.
+	WriteMetadata(ctx context.Context, key string, metadata schema.KvV2WriteMetadataRequest) error
+	// Delete removes key with all its versions and metadata.
+	Delete(ctx context.Context, key string) error
+}
+
+// KVv2Backend stores secrets in a vault KV v2 secrets engine.
+type KVv2Backend struct {
+	client    *vault.Client
+	mountPath string
+}
+
+var _ KVBackend = (*KVv2Backend)(nil)
+
+func NewKVv2Backend(client *vault.Client, mountPath string) *KVv2Backend {
+	return &KVv2Backend{
+		client:    client,
+		mountPath: mountPath,
+	}
+}
+
+func (b *KVv2Backend) List(ctx context.Context, dir string) ([]string, error) {
+	response, err := b.client.Secrets.KvV2List(ctx, dir, vault.WithMountPath(b.mountPath))
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+	return response.Data.Keys, nil
+}
+
+func (b *KVv2Backend) ReadData(ctx context.Context, key string) (map[string]interface{}, error) {
+	response, err := b.client.Secrets.KvV2Read(ctx, key, vault.WithMountPath(b.mountPath))
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+	return response.Data.Data, nil
+}
+
+func (b *KVv2Backend) ReadMetadata(ctx context.Context, key string) (*schema.KvV2ReadMetadataResponse, error) {
+	response, err := b.client.Secrets.KvV2ReadMetadata(ctx, key, vault.WithMountPath(b.mountPath))
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+	return &response.Data, nil
+}
+
+// POST https://vault-test.answeraiops.com/v1/kv/data/chatbot/admin/test
+//
+//	{
+//		"data": {
+//		  "test": "test"
+//		},
+//		"options": {
+//		  "cas": 0
+//		}
+//	  }
+//
+// Response:
+//
+//	{
+//	    "request_id": "ea63c36f-a83b-8b4c-fc9b-0a4173604cd7",
+//	    "lease_id": "",
+//	    "renewable": false,
+//	    "lease_duration": 0,
+//	    "data": {
+//	        "created_time": "2024-12-24T11:36:20.976596673Z",
+//	        "custom_metadata": null,
+//	        "deletion_time": "",
+//	        "destroyed": false,
+//	        "version": 1
+//	    },
+//	    "wrap_info": null,
+//	    "warnings": null,
+//	    "auth": null,
+//	    "mount_type": "kv"
+//	}
+func (b *KVv2Backend) WriteData(ctx context.Context, key string, data map[string]interface{}, cas int64) (int64, error) {
+	response, err := b.client.Secrets.KvV2Write(ctx, key, schema.KvV2WriteRequest{
+		Data: data,
+		Options: map[string]interface{}{
+			"cas": cas,
+		},
+	}, vault.WithMountPath(b.mountPath))
+	if err != nil {
+		return 0, err
+	}
+	return response.Data.Version, nil
+}
+
+// POST https://vault-test.answeraiops.com/v1/kv/metadata/chatbot/admin/test
+//
+//	{
+//		"max_versions": 0,
+//		"cas_required": true,
+//		"delete_version_after": "0s",
+//		"custom_metadata": {
+//		  "test": "test"
+//		}
+//	  }
+func (b *KVv2Backend) WriteMetadata(ctx context.Context, key string, metadata schema.KvV2WriteMetadataRequest) error {
+	_, err := b.client.Secrets.KvV2WriteMetadata(ctx, key, metadata, vault.WithMountPath(b.mountPath))
+	return err
+}
+
+// DELETE https://vault-test.answeraiops.com/v1/kv/metadata/chatbot/admin/test
+func (b *KVv2Backend) Delete(ctx context.Context, key string) error {
+	_, err := b.client.Secrets.KvV2Delete(ctx, key, vault.WithMountPath(b.mountPath))
+	if err != nil {
+		return fmt.Errorf("failed to delete data: %w", err)
+	}
+	_, err = b.client.Secrets.KvV2DeleteMetadataAndAllVersions(ctx, key, vault.WithMountPath(b.mountPath))
+	if err != nil {
+		return fmt.Errorf("failed to delete metadata: %w", err)
+	}
+	return nil
+}
+
+// SoftDelete deletes the current version of key and keeps its history and
+// metadata, so it can be undeleted.
+func (b *KVv2Backend) SoftDelete(ctx context.Context, key string) error {
+	_, err := b.client.Secrets.KvV2Delete(ctx, key, vault.WithMountPath(b.mountPath))
+	return err
+}
+
+// ReadVersion returns the data of a version of key.
+func (b *KVv2Backend) ReadVersion(ctx context.Context, key string, version int64) (map[string]interface{}, error) {
+	response, err := b.client.Secrets.KvV2Read(ctx, key, vault.WithMountPath(b.mountPath),
+		vault.WithQueryParameters(url.Values{"version": {strconv.FormatInt(version, 10)}}))
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+	return response.Data.Data, nil
+}
+
+// KVv1Backend stores secrets in a vault KV v1 secrets engine, which keeps a
+// single version of each key and no metadata.
+type KVv1Backend struct {
+	client    *vault.Client
+	mountPath string
+}
+
+var _ KVBackend = (*KVv1Backend)(nil)
+
+func NewKVv1Backend(client *vault.Client, mountPath string) *KVv1Backend {
+	return &KVv1Backend{
+		client:    client,
+		mountPath: mountPath,
+	}
+}
+
+func (b *KVv1Backend) List(ctx context.Context, dir string) ([]string, error) {
+	response, err := b.client.Secrets.KvV1List(ctx, dir, vault.WithMountPath(b.mountPath))
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+	return response.Data.Keys, nil
+}
+
+func (b *KVv1Backend) ReadData(ctx context.Context, key string) (map[string]interface{}, error) {
+	response, err := b.client.Secrets.KvV1Read(ctx, key, vault.WithMountPath(b.mountPath))
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+	return response.Data, nil
+}
+
+// ReadMetadata returns ErrMetadataUnsupported, KV v1 has no metadata.
+func (b *KVv1Backend) ReadMetadata(ctx context.Context, key string) (*schema.KvV2ReadMetadataResponse, error) {
+	return nil, ErrMetadataUnsupported
+}
+
+// WriteData overwrites key, cas is ignored since KV v1 has no versions.
+func (b *KVv1Backend) WriteData(ctx context.Context, key string, data map[string]interface{}, cas int64) (int64, error) {
+	_, err := b.client.Secrets.KvV1Write(ctx, key, data, vault.WithMountPath(b.mountPath))
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+func (b *KVv1Backend) WriteMetadata(ctx context.Context, key string, metadata schema.KvV2WriteMetadataRequest) error {
+	return ErrMetadataUnsupported
+}
+
+func (b *KVv1Backend) Delete(ctx context.Context, key string) error {
+	_, err := b.client.Secrets.KvV1Delete(ctx, key, vault.WithMountPath(b.mountPath))
+	return err
+}
+
+// SupportsMetadata is false, metadata files are ignored.
+func (b *KVv1Backend) SupportsMetadata() bool {
+	return false
+}
+
+// supportsMetadata reports whether backend stores metadata, backends do unless
+// they have a SupportsMetadata method that says otherwise.
+func supportsMetadata(backend KVBackend) bool {
+	if b, ok := backend.(interface{ SupportsMetadata() bool }); ok {
+		return b.SupportsMetadata()
+	}
+	return true
+}
+
+// softDeleter is implemented by backends that keep the history of keys and
+// can delete their current versions only.
+type softDeleter interface {
+	SoftDelete(ctx context.Context, key string) error
+}
+
+// DetectKVVersion returns the version of the KV secrets engine at mountPath.
+// It reads the mount from sys/internal/ui/mounts, which any token with access
+// to the mount can read, and falls back to sys/mounts. A mount that is not a
+// KV secrets engine is an error.
+func DetectKVVersion(ctx context.Context, client *vault.Client, mountPath string) (int, error) {
+	var mountType string
+	var options map[string]interface{}
+	response, err := client.System.InternalUiReadMountInformation(ctx, mountPath)
+	if err == nil {
+		mountType, options = response.Data.Type, response.Data.Options
+	} else {
+		mountResponse, mountErr := client.System.MountsReadConfiguration(ctx, mountPath)
+		if mountErr != nil {
+			return 0, fmt.Errorf("failed to read mount: %s, %w", mountPath, mountErr)
+		}
+		mountType, options = mountResponse.Data.Type, mountResponse.Data.Options
+	}
+
+	if mountType != "kv" {
+		return 0, fmt.Errorf("not a kv mount: %s, %s", mountPath, mountType)
+	}
+	if fmt.Sprint(options["version"]) == "2" {
+		return 2, nil
+	}
+	return 1, nil
+}
+
+// wrapNotFound makes a 404 response error wrap ErrNotFound.
+func wrapNotFound(err error) error {
+	if vault.IsErrorStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/hashicorp/vault-client-go/schema"
+	"github.com/stretchr/testify/require"
+)
+
+// memBackend is a KVBackend that keeps the current version of each key in
+// memory.
+type memBackend struct {
+	mu   sync.Mutex
+	kvs  map[string]map[string]interface{}
+	meta map[string]*schema.KvV2ReadMetadataResponse
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{
+		kvs:  make(map[string]map[string]interface{}),
+		meta: make(map[string]*schema.KvV2ReadMetadataResponse),
+	}
+}
+
+func (b *memBackend) List(ctx context.Context, dir string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	seen := make(map[string]bool)
+	var names []string
+	for key := range b.meta {
+		if !strings.HasPrefix(key, dir+"/") {
+			continue
+		}
+		name, _, isDir := strings.Cut(strings.TrimPrefix(key, dir+"/"), "/")
+		if isDir {
+			name += "/"
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w: %s", syncer.ErrNotFound, dir)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *memBackend) ReadData(ctx context.Context, key string) (map[string]interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.kvs[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", syncer.ErrNotFound, key)
+	}
+	return data, nil
+}
+
+func (b *memBackend) ReadMetadata(ctx context.Context, key string) (*schema.KvV2ReadMetadataResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	metadata, ok := b.meta[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", syncer.ErrNotFound, key)
+	}
+	copied := *metadata
+	return &copied, nil
+}
+
+func (b *memBackend) WriteData(ctx context.Context, key string, data map[string]interface{}, cas int64) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	metadata, ok := b.meta[key]
+	if !ok {
+		metadata = &schema.KvV2ReadMetadataResponse{DeleteVersionAfter: "0s"}
+		b.meta[key] = metadata
+	}
+	if metadata.CurrentVersion != cas {
+		return 0, fmt.Errorf("check-and-set mismatch: %s, %d != %d", key, cas, metadata.CurrentVersion)
+	}
+	metadata.CurrentVersion++
+	b.kvs[key] = data
+	return metadata.CurrentVersion, nil
+}
+
+func (b *memBackend) WriteMetadata(ctx context.Context, key string, request schema.KvV2WriteMetadataRequest) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	metadata, ok := b.meta[key]
+	if !ok {
+		metadata = &schema.KvV2ReadMetadataResponse{}
+		b.meta[key] = metadata
+	}
+	metadata.CasRequired = request.CasRequired
+	metadata.DeleteVersionAfter = request.DeleteVersionAfter
+	metadata.MaxVersions = int64(request.MaxVersions)
+	metadata.CustomMetadata = request.CustomMetadata
+	return nil
+}
+
+func (b *memBackend) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.kvs, key)
+	delete(b.meta, key)
+	return nil
+}
+
+func TestSyncWithBackend(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemBackend()
+
+	sync := syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: "../testdata/dir1",
+		CasTry:    3,
+	}, backend)
+	err := sync.Sync(ctx)
+	require.NoError(t, err)
+
+	var keys []string
+	err = syncer.WalkBackend(ctx, backend, "unittest", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"unittest/config_1", "unittest/config_2", "unittest/sub1/secret_1"}, keys)
+
+	actions, err := sync.Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+
+	fetchPath := t.TempDir()
+	fetcher := syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: fetchPath,
+	}, backend)
+	err = fetcher.Fetch(ctx)
+	require.NoError(t, err)
+	directoryEqual(t, "../testdata/dir1", fetchPath)
+
+	sync = syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: "../testdata/dir2",
+		CasTry:    3,
+	}, backend)
+	err = sync.Sync(ctx)
+	require.NoError(t, err)
+	fetchPath = t.TempDir()
+	fetcher = syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: fetchPath,
+	}, backend)
+	err = fetcher.Fetch(ctx)
+	require.NoError(t, err)
+	directoryEqual(t, "../testdata/dir2", fetchPath)
+}
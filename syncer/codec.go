@@ -0,0 +1,268 @@
+package syncer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault-client-go/schema"
+	"gopkg.in/yaml.v3"
+)
+
+// Codec reads and writes local secret files of one format.
+type Codec interface {
+	// Extensions returns the file extensions of the format including the
+	// leading dot, e.g. ".yaml". The first one is used for the files Fetch
+	// writes, and without the dot it is the name of the format. The metadata
+	// file of a secret file has the same extension prefixed with ".meta",
+	// unless the codec has a MetadataExtension method that returns another.
+	Extensions() []string
+	// DecodeData decodes the data of a secret file.
+	DecodeData(b []byte) (map[string]interface{}, error)
+	// DecodeMetadata decodes a metadata file.
+	DecodeMetadata(b []byte) (*schema.KvV2WriteMetadataRequest, error)
+	// Encode encodes secret data, a map[string]interface{}, or metadata, a
+	// schema.KvV2WriteMetadataRequest.
+	Encode(v interface{}) ([]byte, error)
+}
+
+// metadataExtensioner is implemented by codecs whose metadata files have
+// another extension than their secret files, e.g. encrypting codecs that keep
+// metadata in plain text.
+type metadataExtensioner interface {
+	// MetadataExtension returns the extension, without ".meta", of the
+	// metadata file of a secret file with extension ext.
+	MetadataExtension(ext string) string
+}
+
+var (
+	codecsMu sync.RWMutex
+	// codecs by extension
+	codecs = make(map[string]Codec)
+)
+
+func init() {
+	RegisterCodec(SOPSCodec{Codec: JSONCodec{}})
+	RegisterCodec(SOPSCodec{Codec: YAMLCodec{}})
+	RegisterCodec(EnvCodec{})
+	RegisterCodec(TOMLCodec{})
+	RegisterCodec(AgeCodec{Codec: JSONCodec{}})
+}
+
+// RegisterCodec makes the extensions of codec secret file extensions for Sync
+// and formats for Fetch, replacing the codecs registered for the same
+// extensions.
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	for _, ext := range codec.Extensions() {
+		codecs[ext] = codec
+	}
+}
+
+// UnregisterCodec removes the extensions of codec, so their files are no
+// longer secret files.
+func UnregisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	for _, ext := range codec.Extensions() {
+		delete(codecs, ext)
+	}
+}
+
+// lookupCodec returns the codec of a secret or metadata file and the extension
+// it matched, the longest registered extension file ends with. The codec is
+// nil if there is none.
+func lookupCodec(file string) (Codec, string) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	var found string
+	for ext := range codecs {
+		if strings.HasSuffix(file, ext) && len(ext) > len(found) {
+			found = ext
+		}
+	}
+	return codecs[found], found
+}
+
+// formatCodec returns the codec of format and the extension of its files.
+func formatCodec(format string) (Codec, string, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	for _, codec := range codecs {
+		ext := codec.Extensions()[0]
+		if ext == "."+format {
+			return codec, ext, nil
+		}
+	}
+	return nil, "", fmt.Errorf("unsupported format: %s, supported formats: %s", format, strings.Join(formatNames(), ", "))
+}
+
+func formatNames() []string {
+	var names []string
+	for ext, codec := range codecs {
+		if codec.Extensions()[0] == ext {
+			names = append(names, strings.TrimPrefix(ext, "."))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// secretExt returns the extension of a secret file, "" if file is a metadata
+// file or has no registered extension.
+func secretExt(file string) string {
+	codec, ext := lookupCodec(file)
+	if codec == nil || strings.HasSuffix(file, ".meta"+ext) {
+		return ""
+	}
+	return ext
+}
+
+// JSONCodec reads and writes .json files.
+type JSONCodec struct{}
+
+func (JSONCodec) Extensions() []string {
+	return []string{".json"}
+}
+
+func (JSONCodec) DecodeData(b []byte) (map[string]interface{}, error) {
+	var data = make(map[string]interface{})
+	err := decodeJson(b, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (JSONCodec) DecodeMetadata(b []byte) (*schema.KvV2WriteMetadataRequest, error) {
+	var metadata schema.KvV2WriteMetadataRequest
+	err := decodeJson(b, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "    ")
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeJson decodes numbers as json.Number, as vault returns them.
+func decodeJson(b []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// YAMLCodec reads and writes .yaml and .yml files.
+type YAMLCodec struct{}
+
+func (YAMLCodec) Extensions() []string {
+	return []string{".yaml", ".yml"}
+}
+
+func (YAMLCodec) DecodeData(b []byte) (map[string]interface{}, error) {
+	var data = make(map[string]interface{})
+	err := decodeYaml(b, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (YAMLCodec) DecodeMetadata(b []byte) (*schema.KvV2WriteMetadataRequest, error) {
+	var metadata schema.KvV2WriteMetadataRequest
+	err := decodeYaml(b, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+func (YAMLCodec) Encode(v interface{}) ([]byte, error) {
+	value, err := toJsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(fromJsonNumbers(value))
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeYaml decodes yaml into v through json, so that json tags apply and
+// numbers are the same as in json files.
+func decodeYaml(b []byte, v interface{}) error {
+	var value interface{}
+	err := yaml.Unmarshal(b, &value)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		// empty file
+		return nil
+	}
+	b, err = json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return decodeJson(b, v)
+}
+
+// toJsonValue converts v to the maps, slices and values it encodes to in
+// json, numbers are json.Number.
+func toJsonValue(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	err = decodeJson(b, &value)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// fromJsonNumbers replaces the json.Number values in v with int64 or float64,
+// which other formats write as numbers rather than strings.
+func fromJsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = fromJsonNumbers(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = fromJsonNumbers(value)
+		}
+	}
+	return v
+}
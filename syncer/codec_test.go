@@ -0,0 +1,225 @@
+package syncer_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/WqyJh/vaultsync/syncer/vaulttest"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestYaml(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{})
+	defer vaultServer.Stop()
+
+	sync := syncer.NewSyncer(syncer.SyncerConfig{
+		VaultAddr:  vaultServer.VaultAddr,
+		VaultToken: vaultServer.RootToken,
+		MountPath:  "kv",
+		VaultPath:  "unittest",
+		LocalPath:  "../testdata/dir4",
+		CasTry:     3,
+	})
+	err := sync.Sync(ctx)
+	require.NoError(t, err)
+
+	// numbers and booleans compare equal to the values read from vault
+	actions, err := sync.Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+
+	client, err := vault.New(vault.WithAddress(vaultServer.VaultAddr))
+	require.NoError(t, err)
+	err = client.SetToken(vaultServer.RootToken)
+	require.NoError(t, err)
+	response, err := client.Secrets.KvV2Read(ctx, "unittest/config_1", vault.WithMountPath("kv"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"key1":    "value1",
+		"port":    json.Number("8080"),
+		"ratio":   json.Number("0.5"),
+		"enabled": true,
+	}, response.Data.Data)
+	metadataResponse, err := client.Secrets.KvV2ReadMetadata(ctx, "unittest/config_1", vault.WithMountPath("kv"))
+	require.NoError(t, err)
+	require.True(t, metadataResponse.Data.CasRequired)
+	require.Equal(t, int64(5), metadataResponse.Data.MaxVersions)
+	require.Equal(t, map[string]interface{}{"meta1": "value1"}, metadataResponse.Data.CustomMetadata)
+
+	fetchPath := t.TempDir()
+	fetcher := syncer.NewFetcher(syncer.SyncerConfig{
+		VaultAddr:  vaultServer.VaultAddr,
+		VaultToken: vaultServer.RootToken,
+		MountPath:  "kv",
+		VaultPath:  "unittest",
+		LocalPath:  fetchPath,
+		Format:     "yaml",
+	})
+	err = fetcher.Fetch(ctx)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(fetchPath, "config_1.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "enabled: true\nkey1: value1\nport: 8080\nratio: 0.5\n", string(b))
+	fileEqual(t, "../testdata/dir4/config_1.meta.yaml", filepath.Join(fetchPath, "config_1.meta.yaml"), true)
+	fileEqual(t, "../testdata/dir4/sub1/secret_1.yml", filepath.Join(fetchPath, "sub1/secret_1.yaml"), false)
+}
+
+func TestDuplicateKey(t *testing.T) {
+	localPath := t.TempDir()
+	err := os.WriteFile(filepath.Join(localPath, "config.json"), []byte(`{"key": "json"}`), 0644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(localPath, "config.yaml"), []byte("key: yaml\n"), 0644)
+	require.NoError(t, err)
+
+	_, err = syncer.LoadDesiredState(localPath, "unittest")
+	require.ErrorContains(t, err, "secret files map to the same key: unittest/config")
+}
+
+// propsCodec reads and writes key=value lines, metadata files hold custom
+// metadata only.
+type propsCodec struct{}
+
+func (propsCodec) Extensions() []string {
+	return []string{".props"}
+}
+
+func (propsCodec) DecodeData(b []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid line: %s", line)
+		}
+		data[key] = value
+	}
+	return data, nil
+}
+
+func (c propsCodec) DecodeMetadata(b []byte) (*schema.KvV2WriteMetadataRequest, error) {
+	custom, err := c.DecodeData(b)
+	if err != nil {
+		return nil, err
+	}
+	return &schema.KvV2WriteMetadataRequest{DeleteVersionAfter: "0s", CustomMetadata: custom}, nil
+}
+
+func (propsCodec) Encode(v interface{}) ([]byte, error) {
+	var data map[string]interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		data = v
+	case schema.KvV2WriteMetadataRequest:
+		data = v.CustomMetadata
+	}
+	var lines []string
+	for key, value := range data {
+		lines = append(lines, fmt.Sprintf("%s=%v\n", key, value))
+	}
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, "")), nil
+}
+
+func TestRegisterCodec(t *testing.T) {
+	syncer.RegisterCodec(propsCodec{})
+	t.Cleanup(func() {
+		syncer.UnregisterCodec(propsCodec{})
+	})
+
+	localPath := "../testdata/dir5"
+	desired, err := syncer.LoadDesiredState(localPath, "unittest")
+	require.NoError(t, err)
+	require.Len(t, desired, 1)
+	require.Equal(t, map[string]interface{}{"user": "admin", "host": "localhost"}, desired["unittest/config"].Data)
+	require.Equal(t, map[string]interface{}{"owner": "unittest"}, desired["unittest/config"].Metadata.CustomMetadata)
+
+	ctx := context.Background()
+	backend := newMemBackend()
+	err = syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: localPath,
+	}, backend).Sync(ctx)
+	require.NoError(t, err)
+
+	fetchPath := t.TempDir()
+	err = syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: fetchPath,
+		Format:    "props",
+	}, backend).Fetch(ctx)
+	require.NoError(t, err)
+	b, err := os.ReadFile(filepath.Join(fetchPath, "config.props"))
+	require.NoError(t, err)
+	require.Equal(t, "host=localhost\nuser=admin\n", string(b))
+	b, err = os.ReadFile(filepath.Join(fetchPath, "config.meta.props"))
+	require.NoError(t, err)
+	require.Equal(t, "owner=unittest\n", string(b))
+
+	err = syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: fetchPath,
+		Format:    "xml",
+	}, backend).Fetch(ctx)
+	require.ErrorContains(t, err, "unsupported format: xml")
+
+	syncer.UnregisterCodec(propsCodec{})
+	desired, err = syncer.LoadDesiredState(localPath, "unittest")
+	require.NoError(t, err)
+	require.Empty(t, desired)
+}
+
+// textPropsCodec is a props codec whose metadata files are .props files.
+type textPropsCodec struct {
+	propsCodec
+}
+
+func (textPropsCodec) Extensions() []string {
+	return []string{".props.txt"}
+}
+
+func (textPropsCodec) MetadataExtension(ext string) string {
+	return ".props"
+}
+
+func TestMetadataExtension(t *testing.T) {
+	syncer.RegisterCodec(propsCodec{})
+	syncer.RegisterCodec(textPropsCodec{})
+	t.Cleanup(func() {
+		syncer.UnregisterCodec(propsCodec{})
+		syncer.UnregisterCodec(textPropsCodec{})
+	})
+
+	ctx := context.Background()
+	backend := newMemBackend()
+	err := syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: "../testdata/dir5",
+	}, backend).Sync(ctx)
+	require.NoError(t, err)
+
+	fetchPath := t.TempDir()
+	err = syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: fetchPath,
+		Format:    "props.txt",
+	}, backend).Fetch(ctx)
+	require.NoError(t, err)
+	b, err := os.ReadFile(filepath.Join(fetchPath, "config.meta.props"))
+	require.NoError(t, err)
+	require.Equal(t, "owner=unittest\n", string(b))
+
+	desired, err := syncer.LoadDesiredState(fetchPath, "unittest")
+	require.NoError(t, err)
+	require.Len(t, desired, 1)
+	require.Equal(t, map[string]interface{}{"owner": "unittest"}, desired["unittest/config"].Metadata.CustomMetadata)
+}
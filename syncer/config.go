@@ -0,0 +1,208 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a vaultsync.yaml file, the mappings of local paths to vault paths
+// synced in one run and the vault they share.
+type Config struct {
+	VaultAddr     string `yaml:"vault_addr"`
+	VaultToken    string `yaml:"vault_token"`
+	VaultRoleId   string `yaml:"role_id"`
+	VaultSecretId string `yaml:"secret_id"`
+	// KubernetesRole, KubernetesJWTPath and KubernetesMount login with the
+	// Kubernetes auth method, see SyncerConfig.
+	KubernetesRole    string `yaml:"kubernetes_role"`
+	KubernetesJWTPath string `yaml:"kubernetes_jwt_path"`
+	KubernetesMount   string `yaml:"kubernetes_mount"`
+	CasTry            int    `yaml:"cas_try"`
+	Concurrency       int    `yaml:"concurrency"`
+	// Mappings are synced in order.
+	Mappings []Mapping `yaml:"mappings"`
+}
+
+// Mapping is a local path synced to a vault path. MaxDeletePercent is nil for
+// DefaultMaxDeletePercent, 0 for no limit.
+type Mapping struct {
+	MountPath        string       `yaml:"mount_path"`
+	VaultPath        string       `yaml:"vault_path"`
+	LocalPath        string       `yaml:"local_path"`
+	KVVersion        int          `yaml:"kv_version"`
+	DeletePolicy     DeletePolicy `yaml:"delete_policy"`
+	MaxDeletes       int          `yaml:"max_deletes"`
+	MaxDeletePercent *float64     `yaml:"max_delete_percent"`
+	ForceDelete      bool         `yaml:"force_delete"`
+	ManagedSource    string       `yaml:"managed_source"`
+	Include          []string     `yaml:"include"`
+	Exclude          []string     `yaml:"exclude"`
+}
+
+func (m Mapping) String() string {
+	return fmt.Sprintf("%s -> %s", m.LocalPath, path.Join(m.MountPath, m.VaultPath))
+}
+
+// MappingResult is the outcome of syncing a mapping. Actions are the actions
+// applied, or planned in a dry run, and Err is the error that stopped the
+// mapping, if any.
+type MappingResult struct {
+	Mapping Mapping
+	Actions []Action
+	Err     error
+}
+
+// ErrMappingsFailed is returned by SyncConfig when some mappings failed.
+var ErrMappingsFailed = errors.New("mappings failed")
+
+// LoadConfig reads a vaultsync.yaml file. Unknown fields are an error, so
+// misspelled settings are not silently ignored.
+func LoadConfig(file string) (*Config, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %s, %w", file, err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	var config Config
+	err = decoder.Decode(&config)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to decode config: %s, %w", file, err)
+	}
+	if len(config.Mappings) == 0 {
+		return nil, fmt.Errorf("no mappings in config: %s", file)
+	}
+	for i, mapping := range config.Mappings {
+		if mapping.MountPath == "" || mapping.LocalPath == "" {
+			return nil, fmt.Errorf("mapping %d needs mount_path and local_path: %s", i+1, file)
+		}
+	}
+	return &config, nil
+}
+
+// SyncerConfig returns the SyncerConfig of mapping with the vault settings of
+// c.
+func (c *Config) SyncerConfig(mapping Mapping) SyncerConfig {
+	maxDeletePercent := float64(DefaultMaxDeletePercent)
+	if mapping.MaxDeletePercent != nil {
+		maxDeletePercent = *mapping.MaxDeletePercent
+	}
+	return SyncerConfig{
+		VaultAddr:         c.VaultAddr,
+		VaultToken:        c.VaultToken,
+		MountPath:         mapping.MountPath,
+		VaultPath:         mapping.VaultPath,
+		LocalPath:         mapping.LocalPath,
+		CasTry:            c.CasTry,
+		VaultRoleId:       c.VaultRoleId,
+		VaultSecretId:     c.VaultSecretId,
+		KubernetesRole:    c.KubernetesRole,
+		KubernetesJWTPath: c.KubernetesJWTPath,
+		KubernetesMount:   c.KubernetesMount,
+		Concurrency:       c.Concurrency,
+		KVVersion:         mapping.KVVersion,
+		DeletePolicy:      mapping.DeletePolicy,
+		MaxDeletes:        mapping.MaxDeletes,
+		MaxDeletePercent:  maxDeletePercent,
+		ForceDelete:       mapping.ForceDelete,
+		ManagedSource:     mapping.ManagedSource,
+		Include:           mapping.Include,
+		Exclude:           mapping.Exclude,
+	}
+}
+
+// SyncConfig syncs every mapping of config, or only plans them if dryRun. A
+// failed mapping does not stop the others, its error is in its result and
+// ErrMappingsFailed is returned with the results of all mappings. The vault is
+// logged in to once for all mappings.
+func SyncConfig(ctx context.Context, config *Config, dryRun bool) ([]MappingResult, error) {
+	shared := *config
+	if shared.VaultToken == "" {
+		vaultConfig := SyncerConfig{
+			VaultAddr:         shared.VaultAddr,
+			VaultRoleId:       shared.VaultRoleId,
+			VaultSecretId:     shared.VaultSecretId,
+			KubernetesRole:    shared.KubernetesRole,
+			KubernetesJWTPath: shared.KubernetesJWTPath,
+			KubernetesMount:   shared.KubernetesMount,
+		}
+		_, err := newVaultAuth(ctx, &vaultConfig)
+		if err != nil {
+			return nil, err
+		}
+		shared.VaultToken = vaultConfig.VaultToken
+	}
+
+	results := make([]MappingResult, len(shared.Mappings))
+	var failed []string
+	for i, mapping := range shared.Mappings {
+		log.Printf("syncing %s", mapping)
+		s := NewSyncer(shared.SyncerConfig(mapping))
+		results[i].Mapping = mapping
+		results[i].Actions, results[i].Err = s.syncMapping(ctx, dryRun)
+		if results[i].Err != nil {
+			log.Printf("failed to sync %s: %+v", mapping, results[i].Err)
+			failed = append(failed, mapping.String())
+		}
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%w: %d of %d: %s", ErrMappingsFailed, len(failed), len(results), strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+// syncMapping syncs s, or plans it if dryRun, and returns the actions.
+func (s *Syncer) syncMapping(ctx context.Context, dryRun bool) ([]Action, error) {
+	if dryRun {
+		return s.Plan(ctx)
+	}
+	backend, err := s.kvBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, actions, err := s.sync(ctx, backend)
+	return actions, err
+}
+
+// PrintReport writes a line for each mapping with the number of actions of
+// each type, or its error.
+func PrintReport(w io.Writer, results []MappingResult) error {
+	for _, result := range results {
+		var line string
+		switch {
+		case result.Err != nil:
+			line = fmt.Sprintf("FAILED %s: %v", result.Mapping, result.Err)
+		case len(result.Actions) == 0:
+			line = fmt.Sprintf("ok     %s: no changes", result.Mapping)
+		default:
+			counts := make(map[ActionType]int)
+			for _, action := range result.Actions {
+				counts[action.Type]++
+			}
+			var types []string
+			for actionType := range counts {
+				types = append(types, string(actionType))
+			}
+			sort.Strings(types)
+			for i, actionType := range types {
+				types[i] = fmt.Sprintf("%d %s", counts[ActionType(actionType)], actionType)
+			}
+			line = fmt.Sprintf("ok     %s: %s", result.Mapping, strings.Join(types, ", "))
+		}
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
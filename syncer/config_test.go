@@ -0,0 +1,110 @@
+package syncer_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/WqyJh/vaultsync/syncer/vaulttest"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncConfig(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{
+		KVv1Mounts: []string{"secret"},
+		AppRoles:   []string{"unittest"},
+	})
+	defer vaultServer.Stop()
+	appRole := vaultServer.AppRoleTokens["unittest"]
+
+	configFile := filepath.Join(t.TempDir(), "vaultsync.yaml")
+	err := os.WriteFile(configFile, []byte(fmt.Sprintf(`vault_addr: %s
+role_id: %s
+secret_id: %s
+cas_try: 3
+mappings:
+  - mount_path: kv
+    vault_path: app1
+    local_path: ../testdata/dir1
+    exclude: [sub1/**]
+  - mount_path: kv
+    vault_path: app2
+    local_path: ../testdata/missing
+  - mount_path: secret
+    vault_path: app3
+    local_path: ../testdata/dir2
+`, vaultServer.VaultAddr, appRole.RoleId, appRole.SecretId)), 0644)
+	require.NoError(t, err)
+	config, err := syncer.LoadConfig(configFile)
+	require.NoError(t, err)
+	require.Len(t, config.Mappings, 3)
+	require.Equal(t, float64(syncer.DefaultMaxDeletePercent), config.SyncerConfig(config.Mappings[0]).MaxDeletePercent)
+
+	results, err := syncer.SyncConfig(ctx, config, true)
+	require.ErrorIs(t, err, syncer.ErrMappingsFailed)
+	require.Len(t, results, 3)
+	require.Len(t, results[0].Actions, 3)
+
+	results, err = syncer.SyncConfig(ctx, config, false)
+	require.ErrorIs(t, err, syncer.ErrMappingsFailed)
+	require.ErrorContains(t, err, "1 of 3: ../testdata/missing -> kv/app2")
+	require.NoError(t, results[0].Err)
+	require.Error(t, results[1].Err)
+	require.NoError(t, results[2].Err)
+
+	var buf bytes.Buffer
+	err = syncer.PrintReport(&buf, results)
+	require.NoError(t, err)
+	require.Equal(t, `ok     ../testdata/dir1 -> kv/app1: 2 create, 1 metadata
+FAILED ../testdata/missing -> kv/app2: `+results[1].Err.Error()+`
+ok     ../testdata/dir2 -> secret/app3: 2 create
+`, buf.String())
+
+	client, err := vault.New(vault.WithAddress(vaultServer.VaultAddr))
+	require.NoError(t, err)
+	err = client.SetToken(vaultServer.RootToken)
+	require.NoError(t, err)
+	_, err = client.Secrets.KvV2Read(ctx, "app1/config_1", vault.WithMountPath("kv"))
+	require.NoError(t, err)
+	_, err = client.Secrets.KvV2Read(ctx, "app1/sub1/secret_1", vault.WithMountPath("kv"))
+	require.True(t, vault.IsErrorStatus(err, 404))
+	_, err = client.Secrets.KvV1Read(ctx, "app3/config_3", vault.WithMountPath("secret"))
+	require.NoError(t, err)
+
+	// a forced mapping deletes more keys than its limits allow
+	err = os.WriteFile(configFile, []byte(fmt.Sprintf(`vault_addr: %s
+vault_token: %s
+mappings:
+  - mount_path: kv
+    vault_path: app1
+    local_path: ../testdata/dir2
+    max_delete_percent: 40
+`, vaultServer.VaultAddr, vaultServer.RootToken)), 0644)
+	require.NoError(t, err)
+	config, err = syncer.LoadConfig(configFile)
+	require.NoError(t, err)
+	_, err = syncer.SyncConfig(ctx, config, false)
+	require.ErrorIs(t, err, syncer.ErrMappingsFailed)
+	require.ErrorContains(t, err, "1 of 1")
+	config.Mappings[0].ForceDelete = true
+	results, err = syncer.SyncConfig(ctx, config, false)
+	require.NoError(t, err)
+	require.Contains(t, results[0].Actions, syncer.Action{
+		Type:     syncer.ActionDelete,
+		Key:      "app1/config_2",
+		Version:  1,
+		Revision: results[0].Actions[len(results[0].Actions)-1].Revision,
+	})
+	require.NotEmpty(t, results[0].Actions[len(results[0].Actions)-1].Revision)
+
+	err = os.WriteFile(configFile, []byte("vault_addr: x\nmapings: []\n"), 0644)
+	require.NoError(t, err)
+	_, err = syncer.LoadConfig(configFile)
+	require.ErrorContains(t, err, "field mapings not found")
+}
@@ -0,0 +1,224 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+// ChangeType is how a key or field changes, printed as its prefix in diffs.
+type ChangeType string
+
+const (
+	ChangeAdded   ChangeType = "+"
+	ChangeRemoved ChangeType = "-"
+	ChangeChanged ChangeType = "~"
+)
+
+// FieldDiff is a change of a single data or metadata field, Old is the value in
+// vault and New is the local value.
+type FieldDiff struct {
+	Change ChangeType
+	Name   string
+	Old    interface{}
+	New    interface{}
+	// Sensitive is true for values that are masked unless revealed, i.e. data
+	// and custom metadata.
+	Sensitive bool
+}
+
+// KeyDiff is the difference between a key in vault and its local file.
+type KeyDiff struct {
+	Change   ChangeType
+	Key      string
+	Data     []FieldDiff
+	Metadata []FieldDiff
+}
+
+// Diff compares the local files with vault and returns the keys that differ,
+// with the data and metadata fields that changed. Keys without local files
+// are removed only if a sync would delete them under DeletePolicy.
+func (s *Syncer) Diff(ctx context.Context) ([]KeyDiff, error) {
+	backend, err := s.kvBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := s.deletePolicy(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	desired, err := s.desiredState(backend)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := s.snapshot(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []KeyDiff
+	for _, key := range syncKeys(desired, snapshot) {
+		if desired[key] == nil && countDeletes(s.planKey(key, nil, snapshot[key], policy)) == 0 {
+			// retained, unmanaged or already deleted
+			continue
+		}
+		diff := diffKV(key, snapshot[key], s.managedSecret(desired[key], snapshot[key]))
+		if diff != nil {
+			diffs = append(diffs, *diff)
+		}
+	}
+	return diffs, nil
+}
+
+// diffKV compares a key in vault with its local secret, local is nil if the
+// local file does not exist and remote is nil if the key is not in vault. It
+// returns nil if there is no difference, a key without local file is removed
+// even if only its metadata is left.
+func diffKV(key string, remote *RemoteKV, local *Secret) *KeyDiff {
+	if remote == nil {
+		remote = &RemoteKV{}
+	}
+	diff := KeyDiff{
+		Change: ChangeChanged,
+		Key:    key,
+	}
+	switch {
+	case local == nil:
+		diff.Change = ChangeRemoved
+		diff.Data = DiffData(remote.Data, nil)
+		return &diff
+	case !remote.Exists:
+		diff.Change = ChangeAdded
+	}
+
+	diff.Data = DiffData(remote.Data, local.Data)
+	if remote.Metadata != nil || local.Metadata != nil {
+		diff.Metadata = DiffMetadata(remote.Metadata, local.Metadata)
+	}
+	if diff.Change == ChangeChanged && len(diff.Data) == 0 && len(diff.Metadata) == 0 {
+		return nil
+	}
+	return &diff
+}
+
+// DiffData returns the fields added, removed or changed from old to new.
+func DiffData(old, new map[string]interface{}) []FieldDiff {
+	return diffFields("", old, new)
+}
+
+// DiffMetadata returns the metadata fields that changed from old in vault to
+// new in the local file. Like MetadataEqual, only custom metadata is compared
+// if new is nil.
+func DiffMetadata(old *schema.KvV2ReadMetadataResponse, new *schema.KvV2WriteMetadataRequest) []FieldDiff {
+	if old == nil {
+		// defaults of a key vault creates without metadata
+		old = &schema.KvV2ReadMetadataResponse{DeleteVersionAfter: "0s"}
+	}
+
+	var oldCustom, newCustom map[string]interface{}
+	if !IsEmptyMap(old.CustomMetadata) {
+		oldCustom = old.CustomMetadata
+	}
+	if new == nil {
+		return diffFields("custom_metadata.", oldCustom, nil)
+	}
+	if !IsEmptyMap(new.CustomMetadata) {
+		newCustom = new.CustomMetadata
+	}
+
+	var diffs []FieldDiff
+	if old.CasRequired != new.CasRequired {
+		diffs = append(diffs, FieldDiff{Change: ChangeChanged, Name: "cas_required", Old: old.CasRequired, New: new.CasRequired})
+	}
+	if old.MaxVersions != int64(new.MaxVersions) {
+		diffs = append(diffs, FieldDiff{Change: ChangeChanged, Name: "max_versions", Old: old.MaxVersions, New: new.MaxVersions})
+	}
+	if old.DeleteVersionAfter != new.DeleteVersionAfter {
+		diffs = append(diffs, FieldDiff{Change: ChangeChanged, Name: "delete_version_after", Old: old.DeleteVersionAfter, New: new.DeleteVersionAfter})
+	}
+	return append(diffs, diffFields("custom_metadata.", oldCustom, newCustom)...)
+}
+
+func diffFields(prefix string, old, new map[string]interface{}) []FieldDiff {
+	names := make([]string, 0, len(old)+len(new))
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diffs []FieldDiff
+	for _, name := range names {
+		oldValue, inOld := old[name]
+		newValue, inNew := new[name]
+		diff := FieldDiff{
+			Name:      prefix + name,
+			Old:       oldValue,
+			New:       newValue,
+			Sensitive: true,
+		}
+		switch {
+		case !inOld:
+			diff.Change = ChangeAdded
+		case !inNew:
+			diff.Change = ChangeRemoved
+		case !reflect.DeepEqual(oldValue, newValue):
+			diff.Change = ChangeChanged
+		default:
+			continue
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+// PrintDiff writes diffs in a human readable form. Data and custom metadata
+// values are masked unless reveal is true.
+func PrintDiff(w io.Writer, diffs []KeyDiff, reveal bool) error {
+	for _, diff := range diffs {
+		_, err := fmt.Fprintf(w, "%s %s\n", diff.Change, diff.Key)
+		if err != nil {
+			return err
+		}
+		for _, fields := range [][]FieldDiff{diff.Data, diff.Metadata} {
+			for _, field := range fields {
+				_, err := fmt.Fprintf(w, "    %s\n", field.format(reveal))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (d FieldDiff) format(reveal bool) string {
+	if d.Sensitive && !reveal {
+		return fmt.Sprintf("%s %s", d.Change, d.Name)
+	}
+	switch d.Change {
+	case ChangeAdded:
+		return fmt.Sprintf("%s %s: %s", d.Change, d.Name, formatValue(d.New))
+	case ChangeRemoved:
+		return fmt.Sprintf("%s %s: %s", d.Change, d.Name, formatValue(d.Old))
+	}
+	return fmt.Sprintf("%s %s: %s => %s", d.Change, d.Name, formatValue(d.Old), formatValue(d.New))
+}
+
+func formatValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
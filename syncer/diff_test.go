@@ -0,0 +1,173 @@
+package syncer_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/WqyJh/vaultsync/syncer/vaulttest"
+	"github.com/hashicorp/vault-client-go/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffData(t *testing.T) {
+	diffs := syncer.DiffData(map[string]interface{}{
+		"a": "1",
+		"b": "2",
+		"c": "3",
+	}, map[string]interface{}{
+		"a": "1",
+		"b": "changed",
+		"d": "4",
+	})
+	require.Equal(t, []syncer.FieldDiff{
+		{Change: syncer.ChangeChanged, Name: "b", Old: "2", New: "changed", Sensitive: true},
+		{Change: syncer.ChangeRemoved, Name: "c", Old: "3", Sensitive: true},
+		{Change: syncer.ChangeAdded, Name: "d", New: "4", Sensitive: true},
+	}, diffs)
+
+	require.Empty(t, syncer.DiffData(map[string]interface{}{"a": "1"}, map[string]interface{}{"a": "1"}))
+}
+
+func TestDiffMetadata(t *testing.T) {
+	remote := &schema.KvV2ReadMetadataResponse{
+		CasRequired:        true,
+		DeleteVersionAfter: "0s",
+		MaxVersions:        5,
+		CustomMetadata: map[string]interface{}{
+			"owner": "a",
+		},
+	}
+
+	diffs := syncer.DiffMetadata(remote, nil)
+	require.Equal(t, []syncer.FieldDiff{
+		{Change: syncer.ChangeRemoved, Name: "custom_metadata.owner", Old: "a", Sensitive: true},
+	}, diffs)
+
+	diffs = syncer.DiffMetadata(remote, &schema.KvV2WriteMetadataRequest{
+		CasRequired:        false,
+		DeleteVersionAfter: "1h0m0s",
+		MaxVersions:        5,
+		CustomMetadata: map[string]interface{}{
+			"owner": "b",
+		},
+	})
+	require.Equal(t, []syncer.FieldDiff{
+		{Change: syncer.ChangeChanged, Name: "cas_required", Old: true, New: false},
+		{Change: syncer.ChangeChanged, Name: "delete_version_after", Old: "0s", New: "1h0m0s"},
+		{Change: syncer.ChangeChanged, Name: "custom_metadata.owner", Old: "a", New: "b", Sensitive: true},
+	}, diffs)
+
+	// cleared custom metadata is empty
+	remote.CustomMetadata = map[string]interface{}{"(empty)": "(empty)"}
+	require.Empty(t, syncer.DiffMetadata(remote, nil))
+}
+
+func TestPrintDiff(t *testing.T) {
+	diffs := []syncer.KeyDiff{
+		{
+			Change: syncer.ChangeChanged,
+			Key:    "unittest/config_1",
+			Data: []syncer.FieldDiff{
+				{Change: syncer.ChangeChanged, Name: "password", Old: "old", New: "new", Sensitive: true},
+				{Change: syncer.ChangeAdded, Name: "user", New: "admin", Sensitive: true},
+			},
+			Metadata: []syncer.FieldDiff{
+				{Change: syncer.ChangeChanged, Name: "max_versions", Old: int64(0), New: int32(5)},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	err := syncer.PrintDiff(&buf, diffs, false)
+	require.NoError(t, err)
+	require.Equal(t, `~ unittest/config_1
+    ~ password
+    + user
+    ~ max_versions: 0 => 5
+`, buf.String())
+
+	buf.Reset()
+	err = syncer.PrintDiff(&buf, diffs, true)
+	require.NoError(t, err)
+	require.Equal(t, `~ unittest/config_1
+    ~ password: "old" => "new"
+    + user: "admin"
+    ~ max_versions: 0 => 5
+`, buf.String())
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{})
+	defer vaultServer.Stop()
+
+	newPolicySyncer := func(localPath string, policy syncer.DeletePolicy) *syncer.Syncer {
+		return syncer.NewSyncer(syncer.SyncerConfig{
+			VaultAddr:    vaultServer.VaultAddr,
+			VaultToken:   vaultServer.RootToken,
+			MountPath:    "kv",
+			VaultPath:    "unittest",
+			LocalPath:    localPath,
+			CasTry:       3,
+			DeletePolicy: policy,
+		})
+	}
+	newSyncer := func(localPath string) *syncer.Syncer {
+		return newPolicySyncer(localPath, "")
+	}
+
+	err := newSyncer("../testdata/dir1").Sync(ctx)
+	require.NoError(t, err)
+
+	diffs, err := newSyncer("../testdata/dir1").Diff(ctx)
+	require.NoError(t, err)
+	require.Empty(t, diffs)
+
+	diffs, err = newSyncer("../testdata/dir2").Diff(ctx)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = syncer.PrintDiff(&buf, diffs, false)
+	require.NoError(t, err)
+	require.Equal(t, `~ unittest/config_1
+    + key2
+    - custom_metadata.meta1
++ unittest/config_3
+    + hello
+- unittest/config_2
+    - key2
+    - key3
+- unittest/sub1/secret_1
+    - secret_1
+`, buf.String())
+
+	// retained keys are not removed
+	diffs, err = newPolicySyncer("../testdata/dir2", syncer.DeleteRetain).Diff(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"unittest/config_1", "unittest/config_3"}, diffKeys(diffs))
+
+	// soft deleted keys are removed only by purge
+	err = newPolicySyncer("../testdata/dir2", syncer.DeleteSoft).Sync(ctx)
+	require.NoError(t, err)
+	diffs, err = newPolicySyncer("../testdata/dir2", syncer.DeleteSoft).Diff(ctx)
+	require.NoError(t, err)
+	require.Empty(t, diffs)
+	diffs, err = newSyncer("../testdata/dir2").Diff(ctx)
+	require.NoError(t, err)
+	buf.Reset()
+	err = syncer.PrintDiff(&buf, diffs, false)
+	require.NoError(t, err)
+	require.Equal(t, `- unittest/config_2
+- unittest/sub1/secret_1
+`, buf.String())
+}
+
+func diffKeys(diffs []syncer.KeyDiff) []string {
+	var keys []string
+	for _, diff := range diffs {
+		keys = append(keys, diff.Key)
+	}
+	return keys
+}
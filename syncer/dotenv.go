@@ -0,0 +1,289 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+// EnvCodec reads and writes .env files of KEY=value lines.
+//
+// Lines starting with # are comments and an "export " prefix is ignored.
+// Values may be unquoted, where a # after a space starts a comment,
+// single-quoted, taken literally, or double-quoted, where \n, \r, \t, \", \\
+// and \$ are escapes. Quoted values may span multiple lines. All values are
+// strings.
+//
+// Metadata files hold the json field names of the metadata as keys, with
+// custom metadata prefixed by "custom_metadata.", e.g.
+//
+//	max_versions=5
+//	custom_metadata.owner=platform
+type EnvCodec struct{}
+
+func (EnvCodec) Extensions() []string {
+	return []string{".env"}
+}
+
+func (EnvCodec) DecodeData(b []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	err := parseEnv(string(b), func(key, value string) {
+		data[key] = value
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (EnvCodec) DecodeMetadata(b []byte) (*schema.KvV2WriteMetadataRequest, error) {
+	var metadata schema.KvV2WriteMetadataRequest
+	var fieldErr error
+	err := parseEnv(string(b), func(key, value string) {
+		var err error
+		switch key {
+		case "cas_required":
+			metadata.CasRequired, err = strconv.ParseBool(value)
+		case "delete_version_after":
+			metadata.DeleteVersionAfter = value
+		case "max_versions":
+			var maxVersions int64
+			maxVersions, err = strconv.ParseInt(value, 10, 32)
+			metadata.MaxVersions = int32(maxVersions)
+		default:
+			name, ok := strings.CutPrefix(key, "custom_metadata.")
+			if !ok {
+				err = fmt.Errorf("unknown metadata field")
+				break
+			}
+			if metadata.CustomMetadata == nil {
+				metadata.CustomMetadata = make(map[string]interface{})
+			}
+			metadata.CustomMetadata[name] = value
+		}
+		if err != nil && fieldErr == nil {
+			fieldErr = fmt.Errorf("invalid metadata field: %s, %w", key, err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fieldErr != nil {
+		return nil, fieldErr
+	}
+	return &metadata, nil
+}
+
+// Encode writes the keys in sorted order. Values that are not strings are
+// written as json.
+func (EnvCodec) Encode(v interface{}) ([]byte, error) {
+	var data map[string]interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		data = v
+	case schema.KvV2WriteMetadataRequest:
+		data = map[string]interface{}{
+			"cas_required":         v.CasRequired,
+			"delete_version_after": v.DeleteVersionAfter,
+			"max_versions":         v.MaxVersions,
+		}
+		for name, value := range v.CustomMetadata {
+			data["custom_metadata."+name] = value
+		}
+	default:
+		return nil, fmt.Errorf("unsupported value: %T", v)
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		if !isEnvKey(key) {
+			return nil, fmt.Errorf("invalid env key: %q", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, key := range keys {
+		value, err := envString(data[key])
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode value: %s, %w", key, err)
+		}
+		sb.WriteString(key)
+		sb.WriteByte('=')
+		sb.WriteString(quoteEnv(value))
+		sb.WriteByte('\n')
+	}
+	return []byte(sb.String()), nil
+}
+
+func envString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case nil:
+		return "", nil
+	case bool, int, int32, int64, float64:
+		return fmt.Sprint(v), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// quoteEnv leaves values of safe characters unquoted and double-quotes the
+// others.
+func quoteEnv(value string) string {
+	safe := value != ""
+	for _, r := range value {
+		if !isEnvKeyRune(r) && !strings.ContainsRune("/:@%+,", r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return value
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '"', '\\', '$':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func isEnvKey(key string) bool {
+	if key == "" || (key[0] >= '0' && key[0] <= '9') {
+		return false
+	}
+	for _, r := range key {
+		if !isEnvKeyRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvKeyRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-'
+}
+
+// parseEnv calls fn for every KEY=value of an env file.
+func parseEnv(s string, fn func(key, value string)) error {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	seen := make(map[string]bool)
+	line := 1
+	for len(s) > 0 {
+		var rest string
+		var stmt string
+		stmt, rest, _ = strings.Cut(s, "\n")
+		trimmed := strings.TrimSpace(stmt)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			s = rest
+			line++
+			continue
+		}
+
+		s = strings.TrimLeft(s, " \t")
+		s = strings.TrimPrefix(s, "export ")
+		s = strings.TrimLeft(s, " \t")
+		end := strings.IndexFunc(s, func(r rune) bool { return !isEnvKeyRune(r) })
+		if end < 0 {
+			end = len(s)
+		}
+		key := s[:end]
+		s = strings.TrimLeft(s[end:], " \t")
+		if !isEnvKey(key) || !strings.HasPrefix(s, "=") {
+			return fmt.Errorf("line %d: expected KEY=value", line)
+		}
+		s = strings.TrimLeft(s[1:], " \t")
+
+		value, rest, lines, err := parseEnvValue(s)
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", line, key, err)
+		}
+		if seen[key] {
+			return fmt.Errorf("line %d: duplicate key: %s", line, key)
+		}
+		seen[key] = true
+		fn(key, value)
+		s = rest
+		line += lines
+	}
+	return nil
+}
+
+// parseEnvValue parses the value at the start of s, it returns the value, the
+// input after its line and the number of lines it spans.
+func parseEnvValue(s string) (string, string, int, error) {
+	if s == "" || (s[0] != '"' && s[0] != '\'') {
+		stmt, rest, _ := strings.Cut(s, "\n")
+		if i := strings.Index(stmt, " #"); i >= 0 {
+			stmt = stmt[:i]
+		} else if i := strings.Index(stmt, "\t#"); i >= 0 {
+			stmt = stmt[:i]
+		}
+		return strings.TrimSpace(stmt), rest, 1, nil
+	}
+
+	quote := s[0]
+	var sb strings.Builder
+	lines := 1
+	i := 1
+	for ; i < len(s) && s[i] != quote; i++ {
+		c := s[i]
+		if c == '\n' {
+			lines++
+		}
+		if c != '\\' || quote == '\'' || i+1 == len(s) {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case '"', '\\', '$':
+			sb.WriteByte(s[i])
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(s[i])
+		}
+	}
+	if i == len(s) {
+		return "", "", 0, fmt.Errorf("unterminated quoted value")
+	}
+
+	after, rest, _ := strings.Cut(s[i+1:], "\n")
+	after = strings.TrimSpace(after)
+	if after != "" && !strings.HasPrefix(after, "#") {
+		return "", "", 0, fmt.Errorf("unexpected characters after quoted value")
+	}
+	return sb.String(), rest, lines, nil
+}
@@ -0,0 +1,121 @@
+package syncer_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/hashicorp/vault-client-go/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvCodecDecode(t *testing.T) {
+	data, err := syncer.EnvCodec{}.DecodeData([]byte(`# database
+export DB_HOST=localhost
+DB_PORT = 5432 # inline comment
+DB_USER=admin#not-a-comment
+EMPTY=
+SINGLE='$literal "\n"'
+DOUBLE="line1\nline2\t\"quoted\" \$HOME \\ # not a comment" # comment
+MULTI="first
+second"
+MULTI_SINGLE='a
+b'
+AFTER=done
+`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"DB_HOST":      "localhost",
+		"DB_PORT":      "5432",
+		"DB_USER":      "admin#not-a-comment",
+		"EMPTY":        "",
+		"SINGLE":       `$literal "\n"`,
+		"DOUBLE":       "line1\nline2\t\"quoted\" $HOME \\ # not a comment",
+		"MULTI":        "first\nsecond",
+		"MULTI_SINGLE": "a\nb",
+		"AFTER":        "done",
+	}, data)
+
+	for _, input := range []string{
+		"KEY",
+		"1KEY=value",
+		`KEY="unterminated`,
+		`KEY="value" trailing`,
+		"KEY=a\nKEY=b",
+	} {
+		_, err := syncer.EnvCodec{}.DecodeData([]byte(input))
+		require.Error(t, err, input)
+	}
+}
+
+func TestEnvCodecEncode(t *testing.T) {
+	data := map[string]interface{}{
+		"B_URL":    "https://example.com/path?a=1",
+		"A_SIMPLE": "value",
+		"C_MULTI":  "line1\nline2",
+		"D_EMPTY":  "",
+		"E_QUOTE":  `say "hi" $USER \o/`,
+		"F_NUMBER": 8080,
+	}
+	b, err := syncer.EnvCodec{}.Encode(data)
+	require.NoError(t, err)
+	require.Equal(t, `A_SIMPLE=value
+B_URL="https://example.com/path?a=1"
+C_MULTI="line1\nline2"
+D_EMPTY=""
+E_QUOTE="say \"hi\" \$USER \\o/"
+F_NUMBER=8080
+`, string(b))
+
+	decoded, err := syncer.EnvCodec{}.DecodeData(b)
+	require.NoError(t, err)
+	data["F_NUMBER"] = "8080"
+	require.Equal(t, data, decoded)
+
+	_, err = syncer.EnvCodec{}.Encode(map[string]interface{}{"invalid key": "value"})
+	require.Error(t, err)
+
+	metadata := schema.KvV2WriteMetadataRequest{
+		CasRequired:        true,
+		DeleteVersionAfter: "0s",
+		MaxVersions:        5,
+		CustomMetadata:     map[string]interface{}{"owner": "platform"},
+	}
+	b, err = syncer.EnvCodec{}.Encode(metadata)
+	require.NoError(t, err)
+	require.Equal(t, "cas_required=true\ncustom_metadata.owner=platform\ndelete_version_after=0s\nmax_versions=5\n", string(b))
+	decodedMetadata, err := syncer.EnvCodec{}.DecodeMetadata(b)
+	require.NoError(t, err)
+	require.Equal(t, &metadata, decodedMetadata)
+}
+
+func TestEnvFiles(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemBackend()
+	err := syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: "../testdata/dir6",
+	}, backend).Sync(ctx)
+	require.NoError(t, err)
+
+	data, err := backend.ReadData(ctx, "unittest/app")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"DB_PASSWORD": "p@ss word", "API_KEY": "abc123"}, data)
+	metadata, err := backend.ReadMetadata(ctx, "unittest/app")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"owner": "platform"}, metadata.CustomMetadata)
+
+	fetchPath := t.TempDir()
+	err = syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: fetchPath,
+		Format:    "env",
+	}, backend).Fetch(ctx)
+	require.NoError(t, err)
+	b, err := os.ReadFile(filepath.Join(fetchPath, "app.env"))
+	require.NoError(t, err)
+	require.Equal(t, "API_KEY=abc123\nDB_PASSWORD=\"p@ss word\"\n", string(b))
+	fileEqual(t, "../testdata/dir6/app.meta.env", filepath.Join(fetchPath, "app.meta.env"), true)
+}
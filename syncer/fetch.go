@@ -1,21 +1,45 @@
 package syncer
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/hashicorp/vault-client-go"
 	"github.com/hashicorp/vault-client-go/schema"
 )
 
 type Fetcher struct {
 	SyncerConfig
+	// OnChange is called with the keys whose files changed after a fetch that
+	// changed any, e.g. ReloadHook.Notify.
+	OnChange func(ctx context.Context, keys []string) error
+	backend  KVBackend
+	// auth is nil if backend was given
+	auth *vaultAuth
+
+	digestsMu sync.Mutex
+	// digests of the files written or found equal, by path
+	digests map[string]fileDigest
+	// fetched is the keys whose files were written or found equal, only their
+	// files are removed when the keys are deleted
+	fetched map[string]bool
+}
+
+// fileDigest is the sha256 of the content of a file and of what it decodes
+// to, so a file that can not be decoded, e.g. encrypted without an identity
+// to decrypt with, is still known to be unchanged.
+type fileDigest struct {
+	file  [sha256.Size]byte
+	value [sha256.Size]byte
 }
 
 func NewFetcher(config SyncerConfig) *Fetcher {
@@ -24,94 +48,341 @@ func NewFetcher(config SyncerConfig) *Fetcher {
 	return &Fetcher{SyncerConfig: config}
 }
 
+// NewFetcherWithBackend returns a Fetcher that reads from backend instead of
+// the vault configured by config.
+func NewFetcherWithBackend(config SyncerConfig, backend KVBackend) *Fetcher {
+	f := NewFetcher(config)
+	f.backend = backend
+	return f
+}
+
+func (f *Fetcher) kvBackend(ctx context.Context) (KVBackend, error) {
+	if f.backend != nil {
+		return f.backend, nil
+	}
+	backend, auth, err := newBackend(ctx, &f.SyncerConfig)
+	if err != nil {
+		return nil, err
+	}
+	f.backend, f.auth = backend, auth
+	return backend, nil
+}
+
+// Fetch writes the keys under VaultPath to files under LocalPath, skipping the
+// files that already hold the same content, and passes the keys of the written
+// files to OnChange.
 func (f *Fetcher) Fetch(ctx context.Context) error {
-	client, err := vault.New(
-		vault.WithAddress(f.VaultAddr),
-		vault.WithRequestTimeout(30*time.Second),
-		vault.WithEnvironment(),
-	)
+	backend, err := f.kvBackend(ctx)
+	if err != nil {
+		return err
+	}
+	var changed []string
+	err = f.auth.do(ctx, func() error {
+		changed, err = f.fetch(ctx, backend, false)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create vault client: %w", err)
+		return err
 	}
+	return f.notify(ctx, changed)
+}
 
-	if f.VaultToken == "" {
-		response, err := client.Auth.AppRoleLogin(ctx, schema.AppRoleLoginRequest{
-			RoleId:   f.VaultRoleId,
-			SecretId: f.VaultSecretId,
-		})
+// Refresh fetches like Fetch and removes the files of the keys that are no
+// longer in vault. It returns the keys whose files were written or removed,
+// after passing them to OnChange.
+func (f *Fetcher) Refresh(ctx context.Context) ([]string, error) {
+	changed, err := f.refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return changed, f.notify(ctx, changed)
+}
+
+func (f *Fetcher) refresh(ctx context.Context) ([]string, error) {
+	backend, err := f.kvBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	err = f.auth.do(ctx, func() error {
+		changed, err = f.fetch(ctx, backend, true)
+		return err
+	})
+	return changed, err
+}
+
+// Run refreshes LocalPath every interval until ctx is done. A failed refresh
+// is logged and retried after a backoff that starts at a second and doubles up
+// to interval. If OnChange fails, the keys are passed to it again after the
+// next refresh. A token got by logging in is renewed before its lease runs
+// out, and replaced by logging in again if vault rejects it.
+func (f *Fetcher) Run(ctx context.Context, interval time.Duration) error {
+	backoff := time.Duration(0)
+	pending := make(map[string]bool)
+	for {
+		wait := interval
+		changed, err := f.refresh(ctx)
+		if err == nil {
+			if len(changed) > 0 {
+				log.Printf("%d keys changed", len(changed))
+			}
+			for _, key := range changed {
+				pending[key] = true
+			}
+			err = f.notify(ctx, sortedKeys(pending))
+		}
 		if err != nil {
-			return fmt.Errorf("failed to login with app role: %w", err)
+			backoff = min(max(2*backoff, time.Second), interval)
+			wait = backoff
+			log.Printf("failed to refresh, retry in %s: %+v", wait, err)
+		} else {
+			backoff = 0
+			pending = make(map[string]bool)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
 		}
-		f.VaultToken = response.Auth.ClientToken
 	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
 
-	err = client.SetToken(f.VaultToken)
+func (f *Fetcher) notify(ctx context.Context, keys []string) error {
+	if f.OnChange == nil || len(keys) == 0 {
+		return nil
+	}
+	err := f.OnChange(ctx, keys)
 	if err != nil {
-		return fmt.Errorf("failed to set vault token: %w", err)
+		return fmt.Errorf("failed to notify change: %w", err)
 	}
+	return nil
+}
 
-	err = WalkKV(ctx, client, f.VaultPath, f.MountPath, func(key string) error {
-		localPath := ToLocalPath(f.LocalPath, f.VaultPath, key)
+// fetch writes the files of the keys under VaultPath whose content differs
+// from vault, and if prune is set removes the files of keys not in vault. It
+// returns the keys whose files changed.
+func (f *Fetcher) fetch(ctx context.Context, backend KVBackend, prune bool) ([]string, error) {
+	format := f.Format
+	if format == "" {
+		format = "json"
+	}
+	codec, ext, err := formatCodec(format)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.checkPatterns()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := LoadSnapshot(ctx, backend, f.VaultPath, f.Concurrency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk kv: %w", err)
+	}
+	f.filterSnapshot(snapshot)
+
+	var keys []string
+	for key, remote := range snapshot {
+		// the current version is deleted
+		if remote.Exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changed := make([]bool, len(keys))
+	err = forEach(ctx, len(keys), f.Concurrency, func(ctx context.Context, i int, logger *log.Logger) error {
+		key := keys[i]
+		remote := snapshot[key]
+		localPath := toLocalPath(f.LocalPath, f.VaultPath, key, ext)
 		err := os.MkdirAll(path.Dir(localPath), 0755)
 		if err != nil {
 			return fmt.Errorf("failed to create directory: %s, %w", path.Dir(localPath), err)
 		}
-		file, err := os.Create(localPath)
+		written, err := f.saveFile(localPath, codec, remote.Data, func(b []byte) bool {
+			data, err := codec.DecodeData(b)
+			return err == nil && MapEqual(data, remote.Data)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to create file: %s, %w", localPath, err)
+			return fmt.Errorf("failed to save data: %s, %w", key, err)
+		}
+		if written {
+			changed[i] = true
+			logger.Printf("[%s] fetch success", key)
+		} else {
+			logger.Printf("[%s] unchanged", key)
 		}
-		defer file.Close()
 
-		response, err := client.Secrets.KvV2Read(ctx, key, vault.WithMountPath(f.MountPath))
-		if err != nil {
-			return fmt.Errorf("failed to read secret: %s, %w", key, err)
+		metadataPath := toMetadataPath(localPath)
+		if remote.Metadata == nil || IsEmptyMap(remote.Metadata.CustomMetadata) {
+			if !prune {
+				return nil
+			}
+			removed, err := removeFile(metadataPath)
+			if err != nil {
+				return fmt.Errorf("failed to remove metadata: %s, %w", key, err)
+			}
+			f.setDigest(metadataPath, nil)
+			if removed {
+				changed[i] = true
+				logger.Printf("[%s] metadata remove success", key)
+			}
+			return nil
 		}
 
-		encoder := json.NewEncoder(file)
-		encoder.SetIndent("", "    ")
-		err = encoder.Encode(response.Data.Data)
+		metadataRequest := schema.KvV2WriteMetadataRequest{
+			CasRequired:        remote.Metadata.CasRequired,
+			DeleteVersionAfter: remote.Metadata.DeleteVersionAfter,
+			MaxVersions:        int32(remote.Metadata.MaxVersions),
+			CustomMetadata:     remote.Metadata.CustomMetadata,
+		}
+		written, err = f.saveFile(metadataPath, codec, metadataRequest, func(b []byte) bool {
+			metadata, err := codec.DecodeMetadata(b)
+			return err == nil && MetadataEqual(remote.Metadata, metadata)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to save data: %s, %w", key, err)
+			return fmt.Errorf("failed to save metadata: %s, %w", key, err)
+		}
+		if written {
+			changed[i] = true
+			logger.Printf("[%s] metadata save success", key)
 		}
 
-		log.Printf("[%s] fetch success", key)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch kv: %w", err)
+	}
 
-		metadataResponse, err := client.Secrets.KvV2ReadMetadata(ctx, key, vault.WithMountPath(f.MountPath))
+	var changedKeys []string
+	for i, key := range keys {
+		if changed[i] {
+			changedKeys = append(changedKeys, key)
+		}
+	}
+	if prune {
+		removedKeys, err := f.removeDeleted(snapshot, ext)
 		if err != nil {
-			return fmt.Errorf("failed to read metadata: %s, %w", key, err)
+			return nil, err
 		}
+		changedKeys = append(changedKeys, removedKeys...)
+		sort.Strings(changedKeys)
+	}
+	if f.fetched == nil {
+		f.fetched = make(map[string]bool, len(keys))
+	}
+	for _, key := range keys {
+		f.fetched[key] = true
+	}
+	return changedKeys, nil
+}
 
-		metadataPath := toMetadataPath(localPath)
-		if IsEmptyMap(metadataResponse.Data.CustomMetadata) {
-			return nil
+// removeDeleted removes the secret files with extension ext, and their
+// metadata files, of the keys fetched before that do not exist in snapshot.
+// Other files under LocalPath are kept. It returns the keys of the removed
+// files.
+func (f *Fetcher) removeDeleted(snapshot Snapshot, ext string) ([]string, error) {
+	var keys []string
+	for _, key := range sortedKeys(f.fetched) {
+		if remote, ok := snapshot[key]; ok && remote.Exists {
+			continue
 		}
-
-		metadataFile, err := os.Create(metadataPath)
-		if err != nil {
-			return fmt.Errorf("failed to create metadata file: %s, %w", metadataPath, err)
+		file := toLocalPath(f.LocalPath, f.VaultPath, key, ext)
+		removed := false
+		for _, file := range []string{file, toMetadataPath(file)} {
+			existed, err := removeFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to remove file: %s, %w", file, err)
+			}
+			f.setDigest(file, nil)
+			removed = removed || existed
 		}
-		defer metadataFile.Close()
-
-		metadataRequest := schema.KvV2WriteMetadataRequest{
-			CasRequired:        metadataResponse.Data.CasRequired,
-			DeleteVersionAfter: metadataResponse.Data.DeleteVersionAfter,
-			MaxVersions:        int32(metadataResponse.Data.MaxVersions),
-			CustomMetadata:     metadataResponse.Data.CustomMetadata,
+		delete(f.fetched, key)
+		if removed {
+			log.Printf("[%s] remove success", key)
+			keys = append(keys, key)
 		}
+	}
+	return keys, nil
+}
 
-		encoder = json.NewEncoder(metadataFile)
-		encoder.SetIndent("", "    ")
-		err = encoder.Encode(metadataRequest)
-		if err != nil {
-			return fmt.Errorf("failed to save metadata: %s, %w", key, err)
+// saveFile writes v to file encoded by codec, unless file already holds it.
+// equal reports whether the content of file decodes to v, it is called as
+// encrypted files differ every time they are encoded. It is not called if
+// file is unchanged since it was last written or found equal to v, so
+// encrypted files that can not be decrypted are not rewritten every time.
+// saveFile returns whether file was written.
+func (f *Fetcher) saveFile(file string, codec Codec, v interface{}, equal func(b []byte) bool) (bool, error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode file: %s, %w", file, err)
+	}
+	digest := fileDigest{value: sha256.Sum256(value)}
+
+	current, readErr := os.ReadFile(file)
+	if readErr == nil {
+		digest.file = sha256.Sum256(current)
+		if f.digest(file) == digest || equal(current) {
+			f.setDigest(file, &digest)
+			return false, nil
 		}
+	}
+	b, err := codec.Encode(v)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode file: %s, %w", file, err)
+	}
+	digest.file = sha256.Sum256(b)
+	if readErr == nil && bytes.Equal(current, b) {
+		f.setDigest(file, &digest)
+		return false, nil
+	}
+	err = os.WriteFile(file, b, 0666)
+	if err != nil {
+		return false, fmt.Errorf("failed to create file: %s, %w", file, err)
+	}
+	f.setDigest(file, &digest)
+	return true, nil
+}
 
-		log.Printf("[%s] metadata save success", key)
+func (f *Fetcher) digest(file string) fileDigest {
+	f.digestsMu.Lock()
+	defer f.digestsMu.Unlock()
+	return f.digests[file]
+}
 
-		return nil
-	})
+// setDigest records the digest of file, nil forgets it.
+func (f *Fetcher) setDigest(file string, digest *fileDigest) {
+	f.digestsMu.Lock()
+	defer f.digestsMu.Unlock()
+	if digest == nil {
+		delete(f.digests, file)
+		return
+	}
+	if f.digests == nil {
+		f.digests = make(map[string]fileDigest)
+	}
+	f.digests[file] = *digest
+}
+
+// removeFile removes file and returns whether it existed.
+func removeFile(file string) (bool, error) {
+	err := os.Remove(file)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to walk kv: %w", err)
+		return false, err
 	}
-	return nil
+	return true, nil
 }
@@ -2,14 +2,17 @@ package syncer_test
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/WqyJh/consul-vault-conf/test"
 	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/WqyJh/vaultsync/syncer/vaulttest"
 	"github.com/hashicorp/vault-client-go/schema"
 	"github.com/stretchr/testify/require"
 )
@@ -17,27 +20,7 @@ import (
 func TestFetch(t *testing.T) {
 
 	ctx := context.Background()
-	vaultServer, err := test.SetupVaultServer(ctx, test.VaultConfig{
-		Policies: []test.VaultPolicy{
-			{
-				Name: "unittest-write",
-				Policy: schema.PoliciesWriteAclPolicyRequest{
-					Policy: `path "kv/data/unittest/*" {
-						policy = "write"
-					}`,
-				},
-			},
-		},
-		AppRoles: []test.VaultAppRole{
-			{
-				Name: "unittest",
-				TokenRules: schema.AppRoleWriteRoleRequest{
-					TokenPolicies: []string{"unittest-write"},
-				},
-			},
-		},
-	})
-	require.NoError(t, err)
+	vaultServer := vaulttest.NewServer(vaulttest.Config{})
 	defer vaultServer.Stop()
 
 	sync := syncer.NewSyncer(syncer.SyncerConfig{
@@ -48,7 +31,7 @@ func TestFetch(t *testing.T) {
 		LocalPath:  "../testdata/dir1",
 		CasTry:     3,
 	})
-	err = sync.Sync(ctx)
+	err := sync.Sync(ctx)
 	require.NoError(t, err)
 
 	fetcher := syncer.NewFetcher(syncer.SyncerConfig{
@@ -147,3 +130,111 @@ func directoryEqual(t *testing.T, dir1, dir2 string) {
 		directoryEqual(t, filepath.Join(dir1, d1.Name()), filepath.Join(dir2, d2.Name()))
 	}
 }
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemBackend()
+	err := syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: "../testdata/dir1",
+	}, backend).Sync(ctx)
+	require.NoError(t, err)
+
+	fetchPath := t.TempDir()
+	fetcher := syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: fetchPath,
+	}, backend)
+	changed, err := fetcher.Refresh(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"unittest/config_1", "unittest/config_2", "unittest/sub1/secret_1"}, changed)
+	directoryEqual(t, "../testdata/dir1", fetchPath)
+
+	// unchanged files are not written
+	config1 := filepath.Join(fetchPath, "config_1.json")
+	err = os.Chtimes(config1, time.Time{}, time.Unix(0, 0))
+	require.NoError(t, err)
+	changed, err = fetcher.Refresh(ctx)
+	require.NoError(t, err)
+	require.Empty(t, changed)
+	info, err := os.Stat(config1)
+	require.NoError(t, err)
+	require.Equal(t, time.Unix(0, 0), info.ModTime())
+
+	_, err = backend.WriteData(ctx, "unittest/config_2", map[string]interface{}{"key2": "changed"}, 1)
+	require.NoError(t, err)
+	err = backend.WriteMetadata(ctx, "unittest/config_1", schema.KvV2WriteMetadataRequest{DeleteVersionAfter: "0s"})
+	require.NoError(t, err)
+	err = backend.Delete(ctx, "unittest/sub1/secret_1")
+	require.NoError(t, err)
+	changed, err = fetcher.Refresh(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"unittest/config_1", "unittest/config_2", "unittest/sub1/secret_1"}, changed)
+
+	data, err := syncer.ReadData(filepath.Join(fetchPath, "config_2.json"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"key2": "changed"}, data)
+	require.NoFileExists(t, filepath.Join(fetchPath, "config_1.meta.json"))
+	require.NoFileExists(t, filepath.Join(fetchPath, "sub1/secret_1.json"))
+	require.FileExists(t, config1)
+
+	// files that were not fetched are kept
+	unrelated := filepath.Join(fetchPath, "unrelated.json")
+	err = os.WriteFile(unrelated, []byte(`{"key": "value"}`), 0644)
+	require.NoError(t, err)
+	changed, err = fetcher.Refresh(ctx)
+	require.NoError(t, err)
+	require.Empty(t, changed)
+	require.FileExists(t, unrelated)
+}
+
+// flakyBackend fails the first failures lists.
+type flakyBackend struct {
+	*memBackend
+	failures atomic.Int32
+}
+
+func (b *flakyBackend) List(ctx context.Context, dir string) ([]string, error) {
+	if b.failures.Add(-1) >= 0 {
+		return nil, fmt.Errorf("vault unavailable")
+	}
+	return b.memBackend.List(ctx, dir)
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backend := &flakyBackend{memBackend: newMemBackend()}
+	err := syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: "../testdata/dir1",
+	}, backend).Sync(ctx)
+	require.NoError(t, err)
+	backend.failures.Store(2)
+
+	fetchPath := t.TempDir()
+	fetcher := syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: fetchPath,
+	}, backend)
+	done := make(chan error)
+	go func() {
+		done <- fetcher.Run(ctx, 20*time.Millisecond)
+	}()
+
+	config2 := filepath.Join(fetchPath, "config_2.json")
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(config2)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	err = backend.Delete(ctx, "unittest/config_2")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(config2)
+		return os.IsNotExist(err)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,58 @@
+package syncer
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// relativeKey returns key relative to VaultPath.
+func (c *SyncerConfig) relativeKey(key string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(key, c.VaultPath), "/")
+}
+
+// underVaultPath reports whether key is under VaultPath.
+func (c *SyncerConfig) underVaultPath(key string) bool {
+	return c.VaultPath == "" || c.VaultPath == "." || strings.HasPrefix(key, c.VaultPath+"/")
+}
+
+// checkPatterns returns an error if a pattern in Include or Exclude is
+// malformed.
+func (c *SyncerConfig) checkPatterns() error {
+	for _, pattern := range append(append([]string(nil), c.Include...), c.Exclude...) {
+		for _, segment := range strings.Split(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid pattern: %s, %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// selected reports whether key is matched by a pattern in Include, or Include
+// is empty, and by no pattern in Exclude.
+func (c *SyncerConfig) selected(key string) bool {
+	segments := strings.Split(c.relativeKey(key), "/")
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if matchSegments(strings.Split(pattern, "/"), segments) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(c.Include) > 0 && !match(c.Include) {
+		return false
+	}
+	return !match(c.Exclude)
+}
+
+// filterSnapshot removes the keys not selected by Include and Exclude from
+// snapshot.
+func (c *SyncerConfig) filterSnapshot(snapshot Snapshot) {
+	for key := range snapshot {
+		if !c.selected(key) {
+			delete(snapshot, key)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package syncer_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncludeExclude(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemBackend()
+	for _, key := range []string{"unittest/payments/a", "unittest/payments/sub/b", "unittest/other"} {
+		_, err := backend.WriteData(ctx, key, map[string]interface{}{"key": "remote"}, 0)
+		require.NoError(t, err)
+	}
+	remoteKeys := func() []string {
+		var keys []string
+		err := syncer.WalkBackend(ctx, backend, "unittest", func(key string) error {
+			keys = append(keys, key)
+			return nil
+		})
+		require.NoError(t, err)
+		sort.Strings(keys)
+		return keys
+	}
+	newSyncer := func(include, exclude []string) *syncer.Syncer {
+		return syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+			VaultPath: "unittest",
+			LocalPath: "../testdata/dir1",
+			CasTry:    3,
+			Include:   include,
+			Exclude:   exclude,
+		}, backend)
+	}
+
+	err := newSyncer([]string{"sub1/**", "config_*"}, []string{"config_2"}).Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"unittest/config_1",
+		"unittest/other",
+		"unittest/payments/a",
+		"unittest/payments/sub/b",
+		"unittest/sub1/secret_1",
+	}, remoteKeys())
+
+	// keys outside the filter are not deleted
+	actions, err := newSyncer([]string{"payments/**"}, []string{"payments/sub/*"}).Plan(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"unittest/payments/a"}, actionKeys(actions))
+	err = newSyncer([]string{"payments/**"}, nil).Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"unittest/config_1",
+		"unittest/other",
+		"unittest/sub1/secret_1",
+	}, remoteKeys())
+
+	_, err = newSyncer([]string{"[payments"}, nil).Plan(ctx)
+	require.ErrorContains(t, err, "invalid pattern: [payments")
+
+	localPath := t.TempDir()
+	err = os.WriteFile(filepath.Join(localPath, "other.json"), []byte(`{"key": "local"}`), 0644)
+	require.NoError(t, err)
+	fetcher := syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: localPath,
+		Exclude:   []string{"other", "config_*"},
+	}, backend)
+	changed, err := fetcher.Refresh(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"unittest/sub1/secret_1"}, changed)
+	require.FileExists(t, filepath.Join(localPath, "sub1/secret_1.json"))
+	require.NoFileExists(t, filepath.Join(localPath, "config_1.json"))
+	b, err := os.ReadFile(filepath.Join(localPath, "other.json"))
+	require.NoError(t, err)
+	require.Equal(t, `{"key": "local"}`, string(b))
+
+	// files of keys outside the filter are not read
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	filteredPath, err := filepath.Rel(wd, t.TempDir())
+	require.NoError(t, err)
+	for name, content := range map[string]string{"payments/a.json": `{"key": "local"}`, "broken/b.json": `{`} {
+		err = os.MkdirAll(filepath.Join(filteredPath, filepath.Dir(name)), 0755)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(filteredPath, name), []byte(content), 0644)
+		require.NoError(t, err)
+	}
+	err = syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: filteredPath,
+		Include:   []string{"payments/**"},
+	}, backend).Sync(ctx)
+	require.NoError(t, err)
+	data, err := backend.ReadData(ctx, "unittest/payments/a")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"key": "local"}, data)
+}
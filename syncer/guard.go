@@ -0,0 +1,69 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrTooManyDeletes is returned when a sync or apply would delete more keys
+// than MaxDeletes or MaxDeletePercent allow. Nothing is written to vault.
+var ErrTooManyDeletes = errors.New("too many deletes")
+
+// DefaultMaxDeletePercent is the MaxDeletePercent of vaultsync and of config
+// mappings that do not set max_delete_percent.
+const DefaultMaxDeletePercent = 50
+
+// isDelete reports whether an action of type t removes data from vault.
+func isDelete(t ActionType) bool {
+	return t == ActionDelete || t == ActionSoftDelete
+}
+
+// isReport reports whether an action of type t only reports a key and
+// changes nothing in vault.
+func isReport(t ActionType) bool {
+	return t == ActionOrphan || t == ActionUnmanaged
+}
+
+// countDeletes returns the number of actions that delete a key.
+func countDeletes(actions []Action) int {
+	n := 0
+	for _, action := range actions {
+		if isDelete(action.Type) {
+			n++
+		}
+	}
+	return n
+}
+
+// checkDeletes returns ErrTooManyDeletes if deleting deletes keys out of total
+// keys in vault exceeds MaxDeletes or MaxDeletePercent, unless ForceDelete.
+func (s *Syncer) checkDeletes(deletes, total int) error {
+	if s.ForceDelete || deletes == 0 {
+		return nil
+	}
+	if s.MaxDeletes > 0 && deletes > s.MaxDeletes {
+		return fmt.Errorf("%w: %d keys would be deleted, more than the limit of %d", ErrTooManyDeletes, deletes, s.MaxDeletes)
+	}
+	if s.MaxDeletePercent > 0 && float64(deletes)*100 > s.MaxDeletePercent*float64(total) {
+		return fmt.Errorf("%w: %d of %d keys would be deleted, more than the limit of %g%%", ErrTooManyDeletes, deletes, total, s.MaxDeletePercent)
+	}
+	return nil
+}
+
+// countKeys returns the number of keys under VaultPath a sync may change,
+// the keys in its snapshot.
+func (s *Syncer) countKeys(ctx context.Context, backend KVBackend) (int, error) {
+	synced, err := s.keyFilter()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = WalkBackend(ctx, backend, s.VaultPath, func(key string) error {
+		if synced(key) {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
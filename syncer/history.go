@@ -0,0 +1,90 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// KeyVersion is a version of a key in its history.
+type KeyVersion struct {
+	Version     int64
+	CreatedTime string
+	// DeletionTime is empty unless the version is deleted.
+	DeletionTime string
+	Destroyed    bool
+}
+
+// versionReader is implemented by backends that keep the history of keys and
+// can read their earlier versions.
+type versionReader interface {
+	ReadVersion(ctx context.Context, key string, version int64) (map[string]interface{}, error)
+}
+
+// History returns the versions of key in vault that are kept, the oldest
+// first.
+func (s *Syncer) History(ctx context.Context, key string) ([]KeyVersion, error) {
+	backend, err := s.kvBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := backend.(versionReader); !ok {
+		return nil, fmt.Errorf("history is not supported by the backend")
+	}
+	metadata, err := backend.ReadMetadata(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %s, %w", key, err)
+	}
+
+	var versions []KeyVersion
+	for number, v := range metadata.Versions {
+		version, err := strconv.ParseInt(number, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version: %s, %w", number, err)
+		}
+		fields, _ := v.(map[string]interface{})
+		createdTime, _ := fields["created_time"].(string)
+		deletionTime, _ := fields["deletion_time"].(string)
+		destroyed, _ := fields["destroyed"].(bool)
+		versions = append(versions, KeyVersion{
+			Version:      version,
+			CreatedTime:  createdTime,
+			DeletionTime: deletionTime,
+			Destroyed:    destroyed,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+// Rollback writes the data of an earlier version of key as its new current
+// version and returns the new version. The history is kept, so a rollback
+// can be rolled back too.
+func (s *Syncer) Rollback(ctx context.Context, key string, version int64) (int64, error) {
+	backend, err := s.kvBackend(ctx)
+	if err != nil {
+		return 0, err
+	}
+	b, ok := backend.(versionReader)
+	if !ok {
+		return 0, fmt.Errorf("rollback is not supported by the backend")
+	}
+	data, err := b.ReadVersion(ctx, key, version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read version: %s, %d, %w", key, version, err)
+	}
+	current, err := currentVersion(ctx, backend, key)
+	if err != nil {
+		return 0, err
+	}
+	newVersion, err := backend.WriteData(ctx, key, data, current)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write data: %s, %w", key, err)
+	}
+	log.Printf("[%s] rollback to version %d success (%d)", key, version, newVersion)
+	return newVersion, nil
+}
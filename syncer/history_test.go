@@ -0,0 +1,64 @@
+package syncer_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/WqyJh/vaultsync/syncer/vaulttest"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryRollback(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{
+		KVv1Mounts: []string{"secret"},
+	})
+	defer vaultServer.Stop()
+
+	newSyncer := func(mountPath, localPath string) *syncer.Syncer {
+		return syncer.NewSyncer(syncer.SyncerConfig{
+			VaultAddr:  vaultServer.VaultAddr,
+			VaultToken: vaultServer.RootToken,
+			MountPath:  mountPath,
+			VaultPath:  "unittest",
+			LocalPath:  localPath,
+			CasTry:     3,
+		})
+	}
+	err := newSyncer("kv", "../testdata/dir1").Sync(ctx)
+	require.NoError(t, err)
+	err = newSyncer("kv", "../testdata/dir2").Sync(ctx)
+	require.NoError(t, err)
+
+	s := newSyncer("kv", "../testdata/dir2")
+	versions, err := s.History(ctx, "unittest/config_1")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, int64(1), versions[0].Version)
+	require.Equal(t, int64(2), versions[1].Version)
+	require.NotEmpty(t, versions[0].CreatedTime)
+	require.Empty(t, versions[1].DeletionTime)
+
+	version, err := s.Rollback(ctx, "unittest/config_1", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), version)
+	client, err := vault.New(vault.WithAddress(vaultServer.VaultAddr))
+	require.NoError(t, err)
+	err = client.SetToken(vaultServer.RootToken)
+	require.NoError(t, err)
+	response, err := client.Secrets.KvV2Read(ctx, "unittest/config_1", vault.WithMountPath("kv"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"key1": "value1"}, response.Data.Data)
+
+	_, err = s.Rollback(ctx, "unittest/config_1", 7)
+	require.ErrorIs(t, err, syncer.ErrNotFound)
+	_, err = s.History(ctx, "unittest/missing")
+	require.ErrorIs(t, err, syncer.ErrNotFound)
+
+	err = newSyncer("secret", "../testdata/dir2").Sync(ctx)
+	require.NoError(t, err)
+	_, err = newSyncer("secret", "../testdata/dir2").History(ctx, "unittest/config_1")
+	require.ErrorContains(t, err, "history is not supported by the backend")
+}
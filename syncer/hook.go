@@ -0,0 +1,95 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ReloadHook tells applications that fetched files changed, by running a
+// command, signaling a process and touching a file, whichever are set.
+type ReloadHook struct {
+	// Command is run with sh -c. The changed keys are in the environment
+	// variable VAULTFETCH_CHANGED_KEYS, one per line.
+	Command string
+	// PidFile is a file holding the pid of the process to send Signal to.
+	PidFile string
+	// Signal defaults to SIGHUP.
+	Signal syscall.Signal
+	// TouchFile is a file whose modification time is set to now, it is
+	// created if it does not exist.
+	TouchFile string
+}
+
+// Notify runs the hook for the changed keys.
+func (h *ReloadHook) Notify(ctx context.Context, keys []string) error {
+	if h.Command != "" {
+		cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+		cmd.Env = append(os.Environ(), "VAULTFETCH_CHANGED_KEYS="+strings.Join(keys, "\n"))
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Errorf("failed to run command: %s, %w", h.Command, err)
+		}
+	}
+
+	if h.PidFile != "" {
+		b, err := os.ReadFile(h.PidFile)
+		if err != nil {
+			return fmt.Errorf("failed to read pid file: %s, %w", h.PidFile, err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return fmt.Errorf("invalid pid file: %s, %w", h.PidFile, err)
+		}
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return fmt.Errorf("failed to find process: %d, %w", pid, err)
+		}
+		signal := h.Signal
+		if signal == 0 {
+			signal = syscall.SIGHUP
+		}
+		err = process.Signal(signal)
+		if err != nil {
+			return fmt.Errorf("failed to signal process: %d, %w", pid, err)
+		}
+	}
+
+	if h.TouchFile != "" {
+		f, err := os.OpenFile(h.TouchFile, os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return fmt.Errorf("failed to create file: %s, %w", h.TouchFile, err)
+		}
+		f.Close()
+		now := time.Now()
+		err = os.Chtimes(h.TouchFile, now, now)
+		if err != nil {
+			return fmt.Errorf("failed to touch file: %s, %w", h.TouchFile, err)
+		}
+	}
+	return nil
+}
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// ParseSignal parses a signal name such as HUP or SIGUSR1.
+func ParseSignal(name string) (syscall.Signal, error) {
+	signal, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported signal: %s", name)
+	}
+	return signal, nil
+}
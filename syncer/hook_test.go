@@ -0,0 +1,91 @@
+//go:build unix
+
+package syncer_test
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadHook(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+	pidFile := filepath.Join(dir, "app.pid")
+	err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	require.NoError(t, err)
+	usr1, err := syncer.ParseSignal("SIGUSR1")
+	require.NoError(t, err)
+	_, err = syncer.ParseSignal("FOO")
+	require.ErrorContains(t, err, "unsupported signal: FOO")
+
+	keysFile := filepath.Join(dir, "keys")
+	touchFile := filepath.Join(dir, "reload")
+	hook := &syncer.ReloadHook{
+		Command:   `printf '%s' "$VAULTFETCH_CHANGED_KEYS" > ` + keysFile,
+		PidFile:   pidFile,
+		Signal:    usr1,
+		TouchFile: touchFile,
+	}
+
+	backend := newMemBackend()
+	err = syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: "../testdata/dir1",
+	}, backend).Sync(ctx)
+	require.NoError(t, err)
+	fetcher := syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: filepath.Join(dir, "secrets"),
+	}, backend)
+	fetcher.OnChange = hook.Notify
+	err = fetcher.Fetch(ctx)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(keysFile)
+	require.NoError(t, err)
+	require.Equal(t, "unittest/config_1\nunittest/config_2\nunittest/sub1/secret_1", string(b))
+	select {
+	case <-signals:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no signal received")
+	}
+	require.FileExists(t, touchFile)
+
+	// nothing changed, the hook is not run
+	err = os.Remove(touchFile)
+	require.NoError(t, err)
+	changed, err := fetcher.Refresh(ctx)
+	require.NoError(t, err)
+	require.Empty(t, changed)
+	require.NoFileExists(t, touchFile)
+
+	_, err = backend.WriteData(ctx, "unittest/config_2", map[string]interface{}{"key2": "changed"}, 1)
+	require.NoError(t, err)
+	changed, err = fetcher.Refresh(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"unittest/config_2"}, changed)
+	b, err = os.ReadFile(keysFile)
+	require.NoError(t, err)
+	require.Equal(t, "unittest/config_2", string(b))
+	require.FileExists(t, touchFile)
+	<-signals
+
+	hook.Command = "exit 1"
+	_, err = backend.WriteData(ctx, "unittest/config_2", map[string]interface{}{"key2": "changed again"}, 2)
+	require.NoError(t, err)
+	_, err = fetcher.Refresh(ctx)
+	require.ErrorContains(t, err, "failed to run command: exit 1")
+}
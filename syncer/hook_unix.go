@@ -0,0 +1,10 @@
+//go:build unix
+
+package syncer
+
+import "syscall"
+
+func init() {
+	signals["USR1"] = syscall.SIGUSR1
+	signals["USR2"] = syscall.SIGUSR2
+}
@@ -0,0 +1,190 @@
+package syncer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IgnoreFile is the name of the files listing local paths that Sync skips, in
+// gitignore syntax. Patterns are relative to the directory of the file and
+// apply to the paths under it.
+const IgnoreFile = ".vaultsyncignore"
+
+// ignoreRule is a line of an ignore file.
+type ignoreRule struct {
+	// segments of the pattern, a leading ** if it matches at any depth
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// ignoreMatcher matches paths under a local path against the ignore files in
+// it and its directories.
+type ignoreMatcher struct {
+	// rules by directory, relative to the local path with / separators
+	rules map[string][]ignoreRule
+}
+
+// loadIgnore reads the ignore files under localPath, skipping ignored
+// directories.
+func loadIgnore(localPath string) (*ignoreMatcher, error) {
+	m := &ignoreMatcher{rules: make(map[string][]ignoreRule)}
+	err := filepath.WalkDir(localPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(localPath, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if m.ignored(rel, true) {
+			return filepath.SkipDir
+		}
+		b, err := os.ReadFile(filepath.Join(filePath, IgnoreFile))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read ignore file: %s, %w", filePath, err)
+		}
+		m.rules[rel] = parseIgnore(b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseIgnore parses the content of an ignore file.
+func parseIgnore(b []byte) []ignoreRule {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			// \# and \!
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		if !strings.Contains(line, "/") {
+			// a name matches at any depth
+			line = "**/" + line
+		}
+		rule.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignored reports whether rel, a path relative to the local path with /
+// separators, is ignored. The last matching rule wins and rules of deeper
+// directories come after those of their parents.
+func (m *ignoreMatcher) ignored(rel string, isDir bool) bool {
+	if rel == "." || rel == "" {
+		return false
+	}
+	// directories of rel from the deepest up to the local path
+	var dirs []string
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, ".")
+
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		name := rel
+		if dir != "." {
+			name = strings.TrimPrefix(rel, dir+"/")
+		}
+		segments := strings.Split(name, "/")
+		for _, rule := range m.rules[dir] {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if matchSegments(rule.segments, segments) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// ignoredKey reports whether a vault key, relative to the vault path, belongs
+// to an ignored path, i.e. one of its directories or a secret file of it is
+// ignored.
+func (m *ignoreMatcher) ignoredKey(rel string) bool {
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if m.ignored(dir, true) {
+			return true
+		}
+	}
+	for _, ext := range secretExts() {
+		if m.ignored(rel+ext, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches the segments of a path against the segments of a glob
+// pattern, where ** matches any number of segments.
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	ok, err := path.Match(pattern[0], name[0])
+	if err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
+
+// secretExts returns the registered secret file extensions, sorted.
+func secretExts() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	exts := make([]string, 0, len(codecs))
+	for ext := range codecs {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
@@ -0,0 +1,54 @@
+package syncer_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnore(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemBackend()
+	for _, key := range []string{"unittest/config.example", "unittest/schema/other", "unittest/sub1/secret_2", "unittest/stale"} {
+		_, err := backend.WriteData(ctx, key, map[string]interface{}{"key": "remote"}, 0)
+		require.NoError(t, err)
+	}
+
+	sync := syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: "../testdata/dir10",
+		CasTry:    3,
+	}, backend)
+	actions, err := sync.Plan(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"unittest/config",
+		"unittest/keep.example",
+		"unittest/sub1/secret_1",
+		"unittest/stale",
+	}, actionKeys(actions))
+
+	err = sync.Sync(ctx)
+	require.NoError(t, err)
+	var keys []string
+	err = syncer.WalkBackend(ctx, backend, "unittest", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(keys)
+	require.Equal(t, []string{
+		"unittest/config",
+		"unittest/config.example",
+		"unittest/keep.example",
+		"unittest/schema/other",
+		"unittest/sub1/secret_1",
+		"unittest/sub1/secret_2",
+	}, keys)
+	data, err := backend.ReadData(ctx, "unittest/sub1/secret_2")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"key": "remote"}, data)
+}
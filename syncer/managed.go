@@ -0,0 +1,141 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+const (
+	// ManagedByKey is the custom metadata field that marks a key as written by
+	// vaultsync, its value is ManagedByValue.
+	ManagedByKey   = "managed_by"
+	ManagedByValue = "vaultsync"
+	// ManagedSourceKey is the custom metadata field holding the ManagedSource
+	// of the sync that wrote a key.
+	ManagedSourceKey = "managed_source"
+)
+
+// isManaged reports whether remote is marked as managed by source.
+func isManaged(remote *RemoteKV, source string) bool {
+	if remote == nil || remote.Metadata == nil {
+		return false
+	}
+	custom := remote.Metadata.CustomMetadata
+	return custom[ManagedByKey] == ManagedByValue && custom[ManagedSourceKey] == source
+}
+
+// managedSecret returns local with the managed marker added to its custom
+// metadata, or local itself if ManagedSource is not set. The settings of
+// remote are kept if local has no metadata file, and a new key gets the
+// defaults of vault.
+func (s *Syncer) managedSecret(local *Secret, remote *RemoteKV) *Secret {
+	if s.ManagedSource == "" || local == nil {
+		return local
+	}
+	metadata := schema.KvV2WriteMetadataRequest{DeleteVersionAfter: "0s"}
+	if local.Metadata != nil {
+		metadata = *local.Metadata
+	} else if remote != nil && remote.Metadata != nil {
+		metadata = clearMetadataRequest(remote.Metadata)
+	}
+	metadata.CustomMetadata = markManaged(metadata.CustomMetadata, s.ManagedSource)
+	return &Secret{
+		Data:     local.Data,
+		Metadata: &metadata,
+	}
+}
+
+// markManaged returns a copy of custom with the managed marker of source.
+func markManaged(custom map[string]interface{}, source string) map[string]interface{} {
+	marked := make(map[string]interface{}, len(custom)+2)
+	if !IsEmptyMap(custom) {
+		for k, v := range custom {
+			marked[k] = v
+		}
+	}
+	marked[ManagedByKey] = ManagedByValue
+	marked[ManagedSourceKey] = source
+	return marked
+}
+
+// planKey is planKey with the managed marker of s. If ManagedSource is set,
+// the marker is written with the key and keys without it are reported as
+// unmanaged instead of being deleted.
+func (s *Syncer) planKey(key string, local *Secret, remote *RemoteKV, policy DeletePolicy) []Action {
+	if s.ManagedSource != "" && local == nil && remote != nil && !isManaged(remote, s.ManagedSource) {
+		return []Action{{
+			Type:    ActionUnmanaged,
+			Key:     key,
+			Version: remote.Version(),
+		}}
+	}
+	return planKey(key, s.managedSecret(local, remote), remote, policy)
+}
+
+// planActions is PlanActions with the managed marker of s.
+func (s *Syncer) planActions(desired DesiredState, snapshot Snapshot, policy DeletePolicy) []Action {
+	var actions []Action
+	for _, key := range syncKeys(desired, snapshot) {
+		actions = append(actions, s.planKey(key, desired[key], snapshot[key], policy)...)
+	}
+	return actions
+}
+
+// Adopt marks keys in vault as managed by ManagedSource, so Sync deletes them
+// when their local files are gone. If no keys are given, the keys with local
+// files are adopted. Keys that are ignored by IgnoreFile or left out by Include
+// and Exclude are never adopted, and neither are keys not in vault.
+func (s *Syncer) Adopt(ctx context.Context, keys ...string) error {
+	if s.ManagedSource == "" {
+		return fmt.Errorf("managed source is not set")
+	}
+	backend, err := s.kvBackend(ctx)
+	if err != nil {
+		return err
+	}
+	if !supportsMetadata(backend) {
+		return fmt.Errorf("managed marker is not supported by the backend")
+	}
+
+	if len(keys) == 0 {
+		desired, err := s.desiredState(backend)
+		if err != nil {
+			return err
+		}
+		keys = syncKeys(desired, nil)
+	}
+	synced, err := s.keyFilter()
+	if err != nil {
+		return err
+	}
+	return forEach(ctx, len(keys), s.Concurrency, func(ctx context.Context, i int, logger *log.Logger) error {
+		key := keys[i]
+		if !s.underVaultPath(key) || !synced(key) {
+			logger.Printf("[%s] not synced, skipped", key)
+			return nil
+		}
+		metadata, err := backend.ReadMetadata(ctx, key)
+		if isNotFound(err) {
+			logger.Printf("[%s] not in vault, skipped", key)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read metadata: %s, %w", key, err)
+		}
+		if isManaged(&RemoteKV{Metadata: metadata}, s.ManagedSource) {
+			logger.Printf("[%s] already managed", key)
+			return nil
+		}
+		request := clearMetadataRequest(metadata)
+		request.CustomMetadata = markManaged(metadata.CustomMetadata, s.ManagedSource)
+		err = backend.WriteMetadata(ctx, key, request)
+		if err != nil {
+			return fmt.Errorf("failed to write metadata: %s, %w", key, err)
+		}
+		logger.Printf("[%s] adopt success", key)
+		return nil
+	})
+}
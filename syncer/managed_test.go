@@ -0,0 +1,139 @@
+package syncer_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/WqyJh/vaultsync/syncer/vaulttest"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManaged(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{
+		KVv1Mounts: []string{"secret"},
+	})
+	defer vaultServer.Stop()
+
+	client, err := vault.New(vault.WithAddress(vaultServer.VaultAddr))
+	require.NoError(t, err)
+	err = client.SetToken(vaultServer.RootToken)
+	require.NoError(t, err)
+
+	newSyncer := func(localPath, source string) *syncer.Syncer {
+		return syncer.NewSyncer(syncer.SyncerConfig{
+			VaultAddr:     vaultServer.VaultAddr,
+			VaultToken:    vaultServer.RootToken,
+			MountPath:     "kv",
+			VaultPath:     "unittest",
+			LocalPath:     localPath,
+			CasTry:        3,
+			ManagedSource: source,
+		})
+	}
+	customMetadata := func(key string) map[string]interface{} {
+		metadata, err := client.Secrets.KvV2ReadMetadata(ctx, key, vault.WithMountPath("kv"))
+		require.NoError(t, err)
+		return metadata.Data.CustomMetadata
+	}
+
+	// a new key only gets the marker
+	diffs, err := newSyncer("../testdata/dir2", "repo1").Diff(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, diffs)
+	for _, diff := range diffs {
+		for _, field := range diff.Metadata {
+			require.Contains(t, []string{"custom_metadata.managed_by", "custom_metadata.managed_source"}, field.Name, diff.Key)
+		}
+	}
+
+	// written by another tool
+	_, err = client.Secrets.KvV2Write(ctx, "unittest/other", schema.KvV2WriteRequest{
+		Data: map[string]interface{}{"key": "value"},
+	}, vault.WithMountPath("kv"))
+	require.NoError(t, err)
+
+	err = newSyncer("../testdata/dir1", "repo1").Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"meta1":          "value1",
+		"managed_by":     "vaultsync",
+		"managed_source": "repo1",
+	}, customMetadata("unittest/config_1"))
+	require.Equal(t, map[string]interface{}{
+		"managed_by":     "vaultsync",
+		"managed_source": "repo1",
+	}, customMetadata("unittest/config_2"))
+
+	actions, err := newSyncer("../testdata/dir1", "repo1").Plan(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []syncer.Action{{Type: syncer.ActionUnmanaged, Key: "unittest/other", Version: 1}}, actions)
+	// the plan does not touch unmanaged keys, so their changes do not make it
+	// stale
+	_, err = client.Secrets.KvV2Write(ctx, "unittest/other", schema.KvV2WriteRequest{
+		Data: map[string]interface{}{"key": "changed"},
+	}, vault.WithMountPath("kv"))
+	require.NoError(t, err)
+	err = newSyncer("../testdata/dir1", "repo1").Apply(ctx, actions)
+	require.NoError(t, err)
+	diffs, err = newSyncer("../testdata/dir1", "repo1").Diff(ctx)
+	require.NoError(t, err)
+	require.Empty(t, diffs)
+
+	// keys of another source are not deleted either
+	actions, err = newSyncer("../testdata/dir2", "repo2").Plan(ctx)
+	require.NoError(t, err)
+	require.Contains(t, actions, syncer.Action{Type: syncer.ActionUnmanaged, Key: "unittest/config_2", Version: 1})
+
+	err = newSyncer("../testdata/dir2", "repo1").Sync(ctx)
+	require.NoError(t, err)
+	_, err = client.Secrets.KvV2ReadMetadata(ctx, "unittest/config_2", vault.WithMountPath("kv"))
+	require.True(t, vault.IsErrorStatus(err, 404))
+	_, err = client.Secrets.KvV2Read(ctx, "unittest/other", vault.WithMountPath("kv"))
+	require.NoError(t, err)
+
+	// only keys with local files are adopted by default, and keys that are
+	// not synced are never adopted
+	err = newSyncer("../testdata/dir2", "repo1").Adopt(ctx)
+	require.NoError(t, err)
+	require.Empty(t, customMetadata("unittest/other"))
+	sync := syncer.NewSyncer(syncer.SyncerConfig{
+		VaultAddr:     vaultServer.VaultAddr,
+		VaultToken:    vaultServer.RootToken,
+		MountPath:     "kv",
+		VaultPath:     "unittest",
+		LocalPath:     "../testdata/dir2",
+		Exclude:       []string{"other"},
+		ManagedSource: "repo1",
+	})
+	err = sync.Adopt(ctx, "unittest/other", "elsewhere/other")
+	require.NoError(t, err)
+	require.Empty(t, customMetadata("unittest/other"))
+
+	err = newSyncer("../testdata/dir2", "repo1").Adopt(ctx, "unittest/other")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"managed_by":     "vaultsync",
+		"managed_source": "repo1",
+	}, customMetadata("unittest/other"))
+	err = newSyncer("../testdata/dir2", "repo1").Sync(ctx)
+	require.NoError(t, err)
+	_, err = client.Secrets.KvV2Read(ctx, "unittest/other", vault.WithMountPath("kv"))
+	require.True(t, vault.IsErrorStatus(err, 404))
+
+	err = newSyncer("../testdata/dir2", "").Adopt(ctx)
+	require.ErrorContains(t, err, "managed source is not set")
+	sync = syncer.NewSyncer(syncer.SyncerConfig{
+		VaultAddr:     vaultServer.VaultAddr,
+		VaultToken:    vaultServer.RootToken,
+		MountPath:     "secret",
+		VaultPath:     "unittest",
+		LocalPath:     "../testdata/dir2",
+		ManagedSource: "repo1",
+	})
+	err = sync.Sync(ctx)
+	require.ErrorContains(t, err, "managed marker is not supported by the backend")
+}
@@ -0,0 +1,151 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+)
+
+// forEach calls fn for the indexes 0 to n-1, running at most concurrency calls
+// at a time. Each call gets its own logger, whose output is written to the
+// standard logger in index order, so the log reads the same as a sequential
+// run. The first error cancels the context passed to the other calls and is
+// returned.
+func forEach(ctx context.Context, n int, concurrency int, fn func(ctx context.Context, i int, logger *log.Logger) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		logs     = make([]*bytes.Buffer, n)
+		flushed  int
+	)
+	// flush writes the logs of the finished calls that are next in order
+	flush := func() {
+		for flushed < n && logs[flushed] != nil {
+			_, _ = log.Writer().Write(logs[flushed].Bytes())
+			logs[flushed] = nil
+			flushed++
+		}
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				var buf bytes.Buffer
+				err := fn(ctx, i, log.New(&buf, log.Prefix(), log.Flags()))
+
+				mu.Lock()
+				logs[i] = &buf
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				flush()
+				mu.Unlock()
+			}
+		}()
+	}
+
+send:
+	for i := 0; i < n; i++ {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	// write the logs of the calls that finished after an earlier index was
+	// never started
+	for i := flushed; i < n; i++ {
+		if logs[i] != nil {
+			_, _ = log.Writer().Write(logs[i].Bytes())
+		}
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return parent.Err()
+}
+
+// listKV returns all the keys under vaultPath in the order WalkBackend visits
+// them, listing at most concurrency directories at a time.
+func listKV(ctx context.Context, backend KVBackend, vaultPath string, concurrency int) ([]string, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return listKVDir(ctx, backend, vaultPath, make(chan struct{}, concurrency))
+}
+
+func listKVDir(ctx context.Context, backend KVBackend, vaultPath string, sem chan struct{}) ([]string, error) {
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	names, err := backend.List(ctx, vaultPath)
+	<-sem
+	if err != nil {
+		return nil, fmt.Errorf("failed to list kv: %s, %w", vaultPath, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		entries  = make([][]string, len(names))
+	)
+	for i, key := range names {
+		key = path.Join(vaultPath, key)
+		if !strings.HasSuffix(names[i], "/") {
+			entries[i] = []string{key}
+			continue
+		}
+		// is a directory
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys, err := listKVDir(ctx, backend, key, sem)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to walk kv: %s, %w", key, err)
+					cancel()
+				}
+				mu.Unlock()
+				return
+			}
+			entries[i] = keys
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		keys = append(keys, entry...)
+	}
+	return keys, nil
+}
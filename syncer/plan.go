@@ -0,0 +1,307 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+// ActionType is the kind of change an Action makes to a vault key.
+type ActionType string
+
+const (
+	// ActionCreate writes the data of a key that does not exist in vault.
+	ActionCreate ActionType = "create"
+	// ActionUpdate writes a new version of a key whose data changed.
+	ActionUpdate ActionType = "update"
+	// ActionDelete deletes a key and all its versions, its local file is gone.
+	ActionDelete ActionType = "delete"
+	// ActionSoftDelete deletes the current version of a key whose local file
+	// is gone and keeps its history, so it can be undeleted.
+	ActionSoftDelete ActionType = "soft-delete"
+	// ActionOrphan reports a key whose local file is gone but that is kept in
+	// vault. Applying it changes nothing.
+	ActionOrphan ActionType = "orphan"
+	// ActionUnmanaged reports a key whose local file is gone but that is not
+	// marked as managed by the sync, so it is not deleted. Applying it changes
+	// nothing.
+	ActionUnmanaged ActionType = "unmanaged"
+	// ActionMetadata writes the metadata of a key.
+	ActionMetadata ActionType = "metadata"
+)
+
+// DeletePolicy is what Sync does with keys whose local files are gone.
+type DeletePolicy string
+
+const (
+	// DeletePurge deletes the keys with all their versions and metadata.
+	DeletePurge DeletePolicy = "purge"
+	// DeleteSoft deletes the current versions of the keys and keeps their
+	// history.
+	DeleteSoft DeletePolicy = "soft"
+	// DeleteRetain keeps the keys and reports them as orphans.
+	DeleteRetain DeletePolicy = "retain"
+)
+
+// Action is a single change Sync would make to vault.
+type Action struct {
+	Type ActionType `json:"type"`
+	Key  string     `json:"key"`
+	// Data is the data to write for create and update actions.
+	Data map[string]interface{} `json:"data,omitempty"`
+	// Metadata is the metadata to write for metadata actions.
+	Metadata *schema.KvV2WriteMetadataRequest `json:"metadata,omitempty"`
+	// Version is the current version of the key in vault when the plan was
+	// made, 0 if the key did not exist.
+	Version int64 `json:"version"`
+	// Revision is the RemoteKV.Revision of the key when the plan was made,
+	// Apply refuses to run if it changed, e.g. by a metadata write.
+	Revision string `json:"revision,omitempty"`
+}
+
+// SavedPlan is a plan written to a file to be applied later.
+type SavedPlan struct {
+	MountPath string   `json:"mount_path"`
+	VaultPath string   `json:"vault_path"`
+	Actions   []Action `json:"actions"`
+}
+
+// ErrStalePlan is returned by Apply when a key changed in vault after the plan
+// was made.
+var ErrStalePlan = errors.New("plan is stale")
+
+func (a Action) String() string {
+	return fmt.Sprintf("%-8s %s", a.Type, a.Key)
+}
+
+// Plan reads the local files and the remote state and returns the actions
+// needed to make vault match the local files, without writing to vault.
+func (s *Syncer) Plan(ctx context.Context) ([]Action, error) {
+	backend, err := s.kvBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := s.deletePolicy(backend)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := s.desiredState(backend)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := s.snapshot(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+	return s.planActions(desired, snapshot, policy), nil
+}
+
+// Apply executes actions made by Plan. Nothing is applied if any key it writes
+// or deletes changed in vault since the plan was made or the plan deletes too
+// many keys, and data is written with the planned versions as check-and-set
+// values, so the reviewed plan is the plan that runs.
+func (s *Syncer) Apply(ctx context.Context, actions []Action) error {
+	backend, err := s.kvBackend(ctx)
+	if err != nil {
+		return err
+	}
+
+	if deletes := countDeletes(actions); deletes > 0 {
+		total, err := s.countKeys(ctx, backend)
+		if err != nil {
+			return err
+		}
+		err = s.checkDeletes(deletes, total)
+		if err != nil {
+			return err
+		}
+	}
+
+	groups := groupByKey(actions)
+	changed := make([]bool, len(groups))
+	err = forEach(ctx, len(groups), s.Concurrency, func(ctx context.Context, i int, logger *log.Logger) error {
+		key := groups[i][0].Key
+		if reportOnly(groups[i]) {
+			// nothing is written, so changes by others do not matter
+			return nil
+		}
+		remote, err := readRevision(ctx, backend, key)
+		if err != nil {
+			return err
+		}
+		if remote.Version() != groups[i][0].Version || remote.Revision() != groups[i][0].Revision {
+			logger.Printf("[%s] changed since plan (version %d -> %d)", key, groups[i][0].Version, remote.Version())
+			changed[i] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var changedKeys []string
+	for i, group := range groups {
+		if changed[i] {
+			changedKeys = append(changedKeys, group[0].Key)
+		}
+	}
+	if len(changedKeys) > 0 {
+		return fmt.Errorf("%w: %d keys changed in vault: %s", ErrStalePlan, len(changedKeys), strings.Join(changedKeys, ", "))
+	}
+
+	return forEach(ctx, len(groups), s.Concurrency, func(ctx context.Context, i int, logger *log.Logger) error {
+		return applyActions(ctx, backend, groups[i], logger)
+	})
+}
+
+// currentVersion returns the current version of key, 0 if it does not exist.
+func currentVersion(ctx context.Context, backend KVBackend, key string) (int64, error) {
+	metadata, err := backend.ReadMetadata(ctx, key)
+	if err != nil {
+		if isNotFound(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read metadata: %s, %w", key, err)
+	}
+	return metadata.CurrentVersion, nil
+}
+
+// readRevision reads what RemoteKV.Revision and RemoteKV.Version of key need,
+// its metadata, or its data if the backend does not store metadata.
+func readRevision(ctx context.Context, backend KVBackend, key string) (*RemoteKV, error) {
+	if !supportsMetadata(backend) {
+		return readRemoteKV(ctx, backend, key)
+	}
+	metadata, err := backend.ReadMetadata(ctx, key)
+	if err != nil {
+		if isNotFound(err) {
+			return &RemoteKV{}, nil
+		}
+		return nil, fmt.Errorf("failed to read metadata: %s, %w", key, err)
+	}
+	return &RemoteKV{Metadata: metadata}, nil
+}
+
+// groupByKey splits actions into runs of consecutive actions on the same key.
+func groupByKey(actions []Action) [][]Action {
+	var groups [][]Action
+	for i, action := range actions {
+		if i > 0 && actions[i-1].Key == action.Key {
+			groups[len(groups)-1] = append(groups[len(groups)-1], action)
+			continue
+		}
+		groups = append(groups, []Action{action})
+	}
+	return groups
+}
+
+// reportOnly reports whether actions change nothing in vault.
+func reportOnly(actions []Action) bool {
+	for _, action := range actions {
+		if !isReport(action.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyActions applies actions in order and stops at the first error.
+func applyActions(ctx context.Context, backend KVBackend, actions []Action, logger *log.Logger) error {
+	for _, action := range actions {
+		err := applyAction(ctx, backend, &action, logger)
+		if err != nil {
+			return fmt.Errorf("failed to %s kv: %s, %w", action.Type, action.Key, err)
+		}
+	}
+	return nil
+}
+
+func applyAction(ctx context.Context, backend KVBackend, action *Action, logger *log.Logger) error {
+	switch action.Type {
+	case ActionCreate, ActionUpdate:
+		version, err := backend.WriteData(ctx, action.Key, action.Data, action.Version)
+		if err != nil {
+			return fmt.Errorf("failed to write data: %w", err)
+		}
+		logger.Printf("[%s] %s success (%d)", action.Key, action.Type, version)
+	case ActionMetadata:
+		err := backend.WriteMetadata(ctx, action.Key, *action.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to write metadata: %w", err)
+		}
+		logger.Printf("[%s] update metadata success", action.Key)
+	case ActionDelete:
+		err := backend.Delete(ctx, action.Key)
+		if err != nil {
+			return err
+		}
+		logger.Printf("[%s] delete data and metadata success", action.Key)
+	case ActionSoftDelete:
+		b, ok := backend.(softDeleter)
+		if !ok {
+			return fmt.Errorf("soft delete is not supported by the backend")
+		}
+		err := b.SoftDelete(ctx, action.Key)
+		if err != nil {
+			return err
+		}
+		logger.Printf("[%s] delete current version success", action.Key)
+	case ActionOrphan:
+		logger.Printf("[%s] no local file, retained", action.Key)
+	case ActionUnmanaged:
+		logger.Printf("[%s] no local file, not managed, retained", action.Key)
+	default:
+		return fmt.Errorf("unknown action type: %s", action.Type)
+	}
+	return nil
+}
+
+// deletePolicy returns the DeletePolicy of s, which defaults to DeletePurge,
+// and checks that backend supports it.
+func (s *Syncer) deletePolicy(backend KVBackend) (DeletePolicy, error) {
+	switch s.DeletePolicy {
+	case "", DeletePurge:
+		return DeletePurge, nil
+	case DeleteSoft:
+		if _, ok := backend.(softDeleter); !ok {
+			return "", fmt.Errorf("soft delete is not supported by the backend")
+		}
+		return DeleteSoft, nil
+	case DeleteRetain:
+		return DeleteRetain, nil
+	}
+	return "", fmt.Errorf("unknown delete policy: %s, must be purge, soft or retain", s.DeletePolicy)
+}
+
+// WritePlan saves plan to file. The file contains the secret data, so it is
+// only readable by the owner.
+func WritePlan(file string, plan *SavedPlan) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create plan file: %s, %w", file, err)
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "    ")
+	err = encoder.Encode(plan)
+	if err != nil {
+		return fmt.Errorf("failed to save plan: %s, %w", file, err)
+	}
+	return nil
+}
+
+func ReadPlan(file string) (*SavedPlan, error) {
+	var plan SavedPlan
+	err := ReadJson(file, &plan)
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
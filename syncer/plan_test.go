@@ -0,0 +1,229 @@
+package syncer_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/WqyJh/vaultsync/syncer/vaulttest"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{})
+	defer vaultServer.Stop()
+
+	newSyncer := func(localPath string) *syncer.Syncer {
+		return syncer.NewSyncer(syncer.SyncerConfig{
+			VaultAddr:  vaultServer.VaultAddr,
+			VaultToken: vaultServer.RootToken,
+			MountPath:  "kv",
+			VaultPath:  "unittest",
+			LocalPath:  localPath,
+			CasTry:     3,
+		})
+	}
+
+	// nothing in vault yet
+	actions, err := newSyncer("../testdata/dir1").Plan(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []syncer.ActionType{
+		syncer.ActionCreate,
+		syncer.ActionMetadata,
+		syncer.ActionCreate,
+		syncer.ActionCreate,
+	}, actionTypes(actions))
+	require.Equal(t, []string{
+		"unittest/config_1",
+		"unittest/config_1",
+		"unittest/config_2",
+		"unittest/sub1/secret_1",
+	}, actionKeys(actions))
+
+	err = newSyncer("../testdata/dir1").Sync(ctx)
+	require.NoError(t, err)
+
+	actions, err = newSyncer("../testdata/dir1").Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+
+	actions, err = newSyncer("../testdata/dir2").Plan(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []syncer.ActionType{
+		syncer.ActionUpdate,
+		syncer.ActionMetadata,
+		syncer.ActionCreate,
+		syncer.ActionDelete,
+		syncer.ActionDelete,
+	}, actionTypes(actions))
+	require.Equal(t, []string{
+		"unittest/config_1",
+		"unittest/config_1",
+		"unittest/config_3",
+		"unittest/config_2",
+		"unittest/sub1/secret_1",
+	}, actionKeys(actions))
+	require.Equal(t, map[string]interface{}{
+		"key1": "value1",
+		"key2": "value2",
+	}, actions[0].Data)
+
+	// plan does not write to vault
+	actions2, err := newSyncer("../testdata/dir2").Plan(ctx)
+	require.NoError(t, err)
+	require.Equal(t, actions, actions2)
+}
+
+func actionTypes(actions []syncer.Action) []syncer.ActionType {
+	var types []syncer.ActionType
+	for _, action := range actions {
+		types = append(types, action.Type)
+	}
+	return types
+}
+
+func actionKeys(actions []syncer.Action) []string {
+	var keys []string
+	for _, action := range actions {
+		keys = append(keys, action.Key)
+	}
+	return keys
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{})
+	defer vaultServer.Stop()
+
+	newSyncer := func(localPath string) *syncer.Syncer {
+		return syncer.NewSyncer(syncer.SyncerConfig{
+			VaultAddr:  vaultServer.VaultAddr,
+			VaultToken: vaultServer.RootToken,
+			MountPath:  "kv",
+			VaultPath:  "unittest",
+			LocalPath:  localPath,
+			CasTry:     3,
+		})
+	}
+
+	err := newSyncer("../testdata/dir1").Sync(ctx)
+	require.NoError(t, err)
+
+	actions, err := newSyncer("../testdata/dir2").Plan(ctx)
+	require.NoError(t, err)
+	require.Len(t, actions, 5)
+
+	planFile := filepath.Join(t.TempDir(), "plan.json")
+	err = syncer.WritePlan(planFile, &syncer.SavedPlan{
+		MountPath: "kv",
+		VaultPath: "unittest",
+		Actions:   actions,
+	})
+	require.NoError(t, err)
+	plan, err := syncer.ReadPlan(planFile)
+	require.NoError(t, err)
+	require.Equal(t, "kv", plan.MountPath)
+	require.Equal(t, "unittest", plan.VaultPath)
+
+	client, err := vault.New(
+		vault.WithAddress(vaultServer.VaultAddr),
+		vault.WithRequestTimeout(30*time.Second),
+	)
+	require.NoError(t, err)
+	err = client.SetToken(vaultServer.RootToken)
+	require.NoError(t, err)
+
+	// a key changed after the plan was made
+	_, err = client.Secrets.KvV2Write(ctx, "unittest/config_2", schema.KvV2WriteRequest{
+		Data: map[string]interface{}{
+			"key2": "changed",
+		},
+	}, vault.WithMountPath("kv"))
+	require.NoError(t, err)
+
+	err = newSyncer("../testdata/dir2").Apply(ctx, plan.Actions)
+	require.ErrorIs(t, err, syncer.ErrStalePlan)
+	_, err = client.Secrets.KvV2Read(ctx, "unittest/config_3", vault.WithMountPath("kv"))
+	require.True(t, vault.IsErrorStatus(err, 404))
+
+	// plan again and apply
+	actions, err = newSyncer("../testdata/dir2").Plan(ctx)
+	require.NoError(t, err)
+	err = newSyncer("../testdata/dir2").Apply(ctx, actions)
+	require.NoError(t, err)
+
+	actions, err = newSyncer("../testdata/dir2").Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+}
+
+func TestApplyMetadataChanged(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{})
+	defer vaultServer.Stop()
+
+	newSyncer := func(localPath string) *syncer.Syncer {
+		return syncer.NewSyncer(syncer.SyncerConfig{
+			VaultAddr:  vaultServer.VaultAddr,
+			VaultToken: vaultServer.RootToken,
+			MountPath:  "kv",
+			VaultPath:  "unittest",
+			LocalPath:  localPath,
+			CasTry:     3,
+		})
+	}
+
+	client, err := vault.New(
+		vault.WithAddress(vaultServer.VaultAddr),
+		vault.WithRequestTimeout(30*time.Second),
+	)
+	require.NoError(t, err)
+	err = client.SetToken(vaultServer.RootToken)
+	require.NoError(t, err)
+
+	err = newSyncer("../testdata/dir1").Sync(ctx)
+	require.NoError(t, err)
+
+	// a key with metadata but no data has version 0
+	_, err = client.Secrets.KvV2WriteMetadata(ctx, "unittest/config_3", schema.KvV2WriteMetadataRequest{
+		CustomMetadata: map[string]interface{}{"owner": "a"},
+	}, vault.WithMountPath("kv"))
+	require.NoError(t, err)
+
+	actions, err := newSyncer("../testdata/dir2").Plan(ctx)
+	require.NoError(t, err)
+	require.Contains(t, actionKeys(actions), "unittest/config_3")
+
+	_, err = client.Secrets.KvV2WriteMetadata(ctx, "unittest/config_3", schema.KvV2WriteMetadataRequest{
+		CustomMetadata: map[string]interface{}{"owner": "b"},
+	}, vault.WithMountPath("kv"))
+	require.NoError(t, err)
+
+	err = newSyncer("../testdata/dir2").Apply(ctx, actions)
+	require.ErrorIs(t, err, syncer.ErrStalePlan)
+	_, err = client.Secrets.KvV2Read(ctx, "unittest/config_3", vault.WithMountPath("kv"))
+	require.True(t, vault.IsErrorStatus(err, 404))
+
+	// metadata of a key with data changed after the plan was made
+	actions, err = newSyncer("../testdata/dir2").Plan(ctx)
+	require.NoError(t, err)
+	_, err = client.Secrets.KvV2WriteMetadata(ctx, "unittest/config_2", schema.KvV2WriteMetadataRequest{
+		MaxVersions: 5,
+	}, vault.WithMountPath("kv"))
+	require.NoError(t, err)
+
+	err = newSyncer("../testdata/dir2").Apply(ctx, actions)
+	require.ErrorIs(t, err, syncer.ErrStalePlan)
+	_, err = client.Secrets.KvV2Read(ctx, "unittest/config_2", vault.WithMountPath("kv"))
+	require.NoError(t, err)
+
+	actions, err = newSyncer("../testdata/dir2").Plan(ctx)
+	require.NoError(t, err)
+	err = newSyncer("../testdata/dir2").Apply(ctx, actions)
+	require.NoError(t, err)
+}
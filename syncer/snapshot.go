@@ -0,0 +1,268 @@
+package syncer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+// RemoteKV is the state of a key in vault.
+type RemoteKV struct {
+	// Exists is false if the current version of the key is deleted.
+	Exists bool
+	Data   map[string]interface{}
+	// Metadata is nil if the key does not exist at all or the backend does
+	// not store metadata.
+	Metadata *schema.KvV2ReadMetadataResponse
+}
+
+// Version returns the current version of the key, 0 if it does not exist.
+func (r *RemoteKV) Version() int64 {
+	if r == nil || r.Metadata == nil {
+		return 0
+	}
+	return r.Metadata.CurrentVersion
+}
+
+// Revision returns a digest of the metadata of the key, which changes with
+// every write of its data or metadata, "" if the key does not exist. Without
+// metadata it is a digest of the data.
+func (r *RemoteKV) Revision() string {
+	switch {
+	case r == nil:
+		return ""
+	case r.Metadata != nil:
+		return digest(r.Metadata)
+	case r.Exists:
+		return digest(r.Data)
+	}
+	return ""
+}
+
+// digest returns the hex sha256 of v encoded as JSON.
+func digest(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Snapshot is the state of every key under a vault path.
+type Snapshot map[string]*RemoteKV
+
+// DesiredState is the local secret of every key that should be in vault.
+type DesiredState map[string]*Secret
+
+// LoadSnapshot lists the keys under vaultPath once and reads their data and
+// metadata, reading at most concurrency keys at a time. A missing vaultPath is
+// an empty snapshot.
+func LoadSnapshot(ctx context.Context, backend KVBackend, vaultPath string, concurrency int) (Snapshot, error) {
+	keys, err := listKV(ctx, backend, vaultPath, concurrency)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("failed to walk vault file: %w", err)
+	}
+
+	remotes := make([]*RemoteKV, len(keys))
+	err = forEach(ctx, len(keys), concurrency, func(ctx context.Context, i int, logger *log.Logger) error {
+		remote, err := readRemoteKV(ctx, backend, keys[i])
+		if err != nil {
+			return fmt.Errorf("failed to read remote kv: %s, %w", keys[i], err)
+		}
+		remotes[i] = remote
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(Snapshot, len(keys))
+	for i, key := range keys {
+		snapshot[key] = remotes[i]
+	}
+	return snapshot, nil
+}
+
+// LoadDesiredState reads every secret file under localPath, keyed by the vault
+// key it maps to under vaultPath.
+func LoadDesiredState(localPath string, vaultPath string) (DesiredState, error) {
+	return loadDesiredState(localPath, vaultPath, nil)
+}
+
+// loadDesiredState is LoadDesiredState reading only the files of the keys
+// selected reports true for, or all files if selected is nil. The files of
+// other keys are not read, so they may be malformed or undecryptable.
+func loadDesiredState(localPath string, vaultPath string, selected func(key string) bool) (DesiredState, error) {
+	files, err := localFiles(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk local file: %w", err)
+	}
+
+	desired := make(DesiredState, len(files))
+	keyFiles := make(map[string]string, len(files))
+	for _, filePath := range files {
+		key := toVaultKey(localPath, filePath, vaultPath)
+		if selected != nil && !selected(key) {
+			continue
+		}
+		if other, ok := keyFiles[key]; ok {
+			return nil, fmt.Errorf("secret files map to the same key: %s, %s and %s", key, other, filePath)
+		}
+		keyFiles[key] = filePath
+
+		secret, err := ReadLocalSecret(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret file: %s, %w", filePath, err)
+		}
+		desired[key] = secret
+	}
+	return desired, nil
+}
+
+func readRemoteKV(ctx context.Context, backend KVBackend, key string) (*RemoteKV, error) {
+	var remote RemoteKV
+	if supportsMetadata(backend) {
+		metadata, err := backend.ReadMetadata(ctx, key)
+		if err != nil {
+			if isNotFound(err) {
+				return &remote, nil
+			}
+			return nil, fmt.Errorf("failed to read metadata: %w", err)
+		}
+		remote.Metadata = metadata
+	}
+
+	data, err := backend.ReadData(ctx, key)
+	if err != nil {
+		if isNotFound(err) {
+			return &remote, nil
+		}
+		return nil, fmt.Errorf("failed to read kv: %w", err)
+	}
+	remote.Exists = true
+	remote.Data = data
+	return &remote, nil
+}
+
+// syncKeys returns the keys to reconcile, the desired keys sorted, then the
+// keys only in the snapshot sorted.
+func syncKeys(desired DesiredState, snapshot Snapshot) []string {
+	keys := make([]string, 0, len(desired))
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var deleted []string
+	for key := range snapshot {
+		if _, ok := desired[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Strings(deleted)
+	return append(keys, deleted...)
+}
+
+// PlanActions returns the actions that make the snapshot match the desired
+// state, keys not in the desired state are handled according to policy. It
+// does not talk to vault.
+func PlanActions(desired DesiredState, snapshot Snapshot, policy DeletePolicy) []Action {
+	var actions []Action
+	for _, key := range syncKeys(desired, snapshot) {
+		actions = append(actions, planKey(key, desired[key], snapshot[key], policy)...)
+	}
+	return actions
+}
+
+// planKey returns the actions that make remote match local, local is nil if
+// the key should be deleted according to policy and remote is nil if the key
+// is not in vault.
+func planKey(key string, local *Secret, remote *RemoteKV, policy DeletePolicy) []Action {
+	version := remote.Version()
+	revision := remote.Revision()
+	if local == nil {
+		if remote == nil {
+			return nil
+		}
+		actionType := ActionDelete
+		switch policy {
+		case DeleteSoft:
+			if !remote.Exists {
+				// already deleted
+				return nil
+			}
+			actionType = ActionSoftDelete
+		case DeleteRetain:
+			actionType = ActionOrphan
+		}
+		action := Action{
+			Type:    actionType,
+			Key:     key,
+			Version: version,
+		}
+		if !isReport(actionType) {
+			// report-only actions are not checked by Apply
+			action.Revision = revision
+		}
+		return []Action{action}
+	}
+	if remote == nil {
+		remote = &RemoteKV{}
+	}
+
+	var actions []Action
+	if !remote.Exists {
+		actions = append(actions, Action{
+			Type:     ActionCreate,
+			Key:      key,
+			Data:     local.Data,
+			Version:  version,
+			Revision: revision,
+		})
+	} else if !MapEqual(remote.Data, local.Data) {
+		actions = append(actions, Action{
+			Type:     ActionUpdate,
+			Key:      key,
+			Data:     local.Data,
+			Version:  version,
+			Revision: revision,
+		})
+	}
+
+	if remote.Metadata == nil {
+		if local.Metadata != nil {
+			actions = append(actions, Action{
+				Type:     ActionMetadata,
+				Key:      key,
+				Metadata: local.Metadata,
+				Version:  version,
+				Revision: revision,
+			})
+		}
+		return actions
+	}
+
+	if MetadataEqual(remote.Metadata, local.Metadata) {
+		return actions
+	}
+
+	metadata := local.Metadata
+	if metadata == nil {
+		request := clearMetadataRequest(remote.Metadata)
+		metadata = &request
+	}
+	return append(actions, Action{
+		Type:     ActionMetadata,
+		Key:      key,
+		Metadata: metadata,
+		Version:  version,
+		Revision: revision,
+	})
+}
@@ -0,0 +1,104 @@
+package syncer_test
+
+import (
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/hashicorp/vault-client-go/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanActions(t *testing.T) {
+	metadata := &schema.KvV2WriteMetadataRequest{
+		MaxVersions:        10,
+		DeleteVersionAfter: "0s",
+		CustomMetadata:     map[string]interface{}{"owner": "unittest"},
+	}
+	desired := syncer.DesiredState{
+		"unittest/create":    {Data: map[string]interface{}{"a": "1"}},
+		"unittest/update":    {Data: map[string]interface{}{"a": "2"}},
+		"unittest/same":      {Data: map[string]interface{}{"a": "1"}},
+		"unittest/metadata":  {Data: map[string]interface{}{"a": "1"}, Metadata: metadata},
+		"unittest/clear":     {Data: map[string]interface{}{"a": "1"}},
+		"unittest/undeleted": {Data: map[string]interface{}{"a": "1"}},
+	}
+	snapshot := syncer.Snapshot{
+		"unittest/update": {
+			Exists:   true,
+			Data:     map[string]interface{}{"a": "1"},
+			Metadata: &schema.KvV2ReadMetadataResponse{CurrentVersion: 1, DeleteVersionAfter: "0s"},
+		},
+		"unittest/same": {
+			Exists:   true,
+			Data:     map[string]interface{}{"a": "1"},
+			Metadata: &schema.KvV2ReadMetadataResponse{CurrentVersion: 3, DeleteVersionAfter: "0s"},
+		},
+		"unittest/metadata": {
+			Exists:   true,
+			Data:     map[string]interface{}{"a": "1"},
+			Metadata: &schema.KvV2ReadMetadataResponse{CurrentVersion: 1, DeleteVersionAfter: "0s"},
+		},
+		"unittest/clear": {
+			Exists: true,
+			Data:   map[string]interface{}{"a": "1"},
+			Metadata: &schema.KvV2ReadMetadataResponse{
+				CurrentVersion:     1,
+				MaxVersions:        5,
+				DeleteVersionAfter: "0s",
+				CustomMetadata:     map[string]interface{}{"owner": "unittest"},
+			},
+		},
+		// the current version is soft deleted
+		"unittest/undeleted": {
+			Metadata: &schema.KvV2ReadMetadataResponse{CurrentVersion: 2, DeleteVersionAfter: "0s"},
+		},
+		"unittest/delete": {
+			Exists:   true,
+			Data:     map[string]interface{}{"a": "1"},
+			Metadata: &schema.KvV2ReadMetadataResponse{CurrentVersion: 4, DeleteVersionAfter: "0s"},
+		},
+	}
+
+	actions := syncer.PlanActions(desired, snapshot, syncer.DeletePurge)
+	require.Equal(t, []string{
+		"unittest/clear",
+		"unittest/create",
+		"unittest/metadata",
+		"unittest/undeleted",
+		"unittest/update",
+		"unittest/delete",
+	}, actionKeys(actions))
+	require.Equal(t, []syncer.ActionType{
+		syncer.ActionMetadata,
+		syncer.ActionCreate,
+		syncer.ActionMetadata,
+		syncer.ActionCreate,
+		syncer.ActionUpdate,
+		syncer.ActionDelete,
+	}, actionTypes(actions))
+
+	// custom metadata is cleared, other fields are kept
+	require.Equal(t, int32(5), actions[0].Metadata.MaxVersions)
+	require.True(t, syncer.IsEmptyMap(actions[0].Metadata.CustomMetadata))
+	require.Equal(t, int64(0), actions[1].Version)
+	require.Equal(t, metadata, actions[2].Metadata)
+	// writing a soft deleted key checks against its current version
+	require.Equal(t, int64(2), actions[3].Version)
+	require.Equal(t, int64(1), actions[4].Version)
+	require.Equal(t, int64(4), actions[5].Version)
+
+	require.Empty(t, syncer.PlanActions(syncer.DesiredState{}, syncer.Snapshot{}, syncer.DeletePurge))
+
+	// keys without local files, the second one is soft deleted
+	snapshot = syncer.Snapshot{
+		"unittest/delete":  snapshot["unittest/delete"],
+		"unittest/deleted": snapshot["unittest/undeleted"],
+	}
+	actions = syncer.PlanActions(syncer.DesiredState{}, snapshot, syncer.DeletePurge)
+	require.Equal(t, []syncer.ActionType{syncer.ActionDelete, syncer.ActionDelete}, actionTypes(actions))
+	actions = syncer.PlanActions(syncer.DesiredState{}, snapshot, syncer.DeleteSoft)
+	require.Equal(t, []syncer.ActionType{syncer.ActionSoftDelete}, actionTypes(actions))
+	require.Equal(t, []string{"unittest/delete"}, actionKeys(actions))
+	actions = syncer.PlanActions(syncer.DesiredState{}, snapshot, syncer.DeleteRetain)
+	require.Equal(t, []syncer.ActionType{syncer.ActionOrphan, syncer.ActionOrphan}, actionTypes(actions))
+}
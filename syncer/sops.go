@@ -0,0 +1,228 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/keys"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/pgp"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"github.com/getsops/sops/v3/version"
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+// SOPSCodec reads json and yaml files that may be encrypted with sops, files
+// with a top level "sops" key are decrypted before they are decoded, and can
+// write secret files encrypted with sops.
+//
+// Files encrypted for age are decrypted with the identities in AgeKeyFile,
+// files encrypted for pgp with the secret keys in PGPSecRing. Without them,
+// and for the other key types, the keys are found the way the sops command
+// finds them, e.g. age identities from SOPS_AGE_KEY_FILE or SOPS_AGE_KEY and
+// pgp keys from gpg.
+type SOPSCodec struct {
+	// Codec is the codec of the plain files, JSONCodec or YAMLCodec.
+	Codec Codec
+	// AgeKeyFile is the path of a file of age identities.
+	AgeKeyFile string
+	// PGPSecRing is the path of a pgp secret keyring.
+	PGPSecRing string
+	// Recipients are the age recipients, "age1...", and pgp fingerprints
+	// Encode encrypts secret data for. Secret data is written in plain text
+	// if there are none, metadata always is.
+	Recipients []string
+}
+
+func (c SOPSCodec) Extensions() []string {
+	return c.Codec.Extensions()
+}
+
+func (c SOPSCodec) DecodeData(b []byte) (map[string]interface{}, error) {
+	b, err := c.decrypt(b)
+	if err != nil {
+		return nil, err
+	}
+	return c.Codec.DecodeData(b)
+}
+
+func (c SOPSCodec) DecodeMetadata(b []byte) (*schema.KvV2WriteMetadataRequest, error) {
+	b, err := c.decrypt(b)
+	if err != nil {
+		return nil, err
+	}
+	return c.Codec.DecodeMetadata(b)
+}
+
+func (c SOPSCodec) Encode(v interface{}) ([]byte, error) {
+	b, err := c.Codec.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.(map[string]interface{}); !ok || len(c.Recipients) == 0 {
+		return b, nil
+	}
+	return c.encrypt(b)
+}
+
+func (c SOPSCodec) store() (sops.Store, error) {
+	switch c.Codec.Extensions()[0] {
+	case ".json":
+		return sopsjson.NewStore(&config.JSONStoreConfig{Indent: 4}), nil
+	case ".yaml":
+		return sopsyaml.NewStore(&config.YAMLStoreConfig{Indent: 2}), nil
+	}
+	return nil, fmt.Errorf("unsupported sops format: %s", c.Codec.Extensions()[0])
+}
+
+// decrypt returns the plain text of b, b itself if it is not encrypted.
+func (c SOPSCodec) decrypt(b []byte) ([]byte, error) {
+	data, err := c.Codec.DecodeData(b)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := data["sops"]; !ok {
+		return b, nil
+	}
+
+	store, err := c.store()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := store.LoadEncryptedFile(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sops file: %w", err)
+	}
+	keyService, err := c.keyService()
+	if err != nil {
+		return nil, err
+	}
+	key, err := tree.Metadata.GetDataKeyWithKeyServices([]keyservice.KeyServiceClient{
+		keyservice.NewCustomLocalClient(keyService),
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sops data key: %w", err)
+	}
+
+	cipher := aes.NewCipher()
+	mac, err := tree.Decrypt(key, cipher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt sops file: %w", err)
+	}
+	originalMac, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, key, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt sops mac: %w", err)
+	}
+	if originalMac != mac {
+		return nil, fmt.Errorf("sops mac mismatch: %s != %s", originalMac, mac)
+	}
+	return store.EmitPlainFile(tree.Branches)
+}
+
+// encrypt encrypts the plain text b for the recipients.
+func (c SOPSCodec) encrypt(b []byte) ([]byte, error) {
+	store, err := c.store()
+	if err != nil {
+		return nil, err
+	}
+	branches, err := store.LoadPlainFile(b)
+	if err != nil {
+		return nil, err
+	}
+	var group sops.KeyGroup
+	for _, recipient := range c.Recipients {
+		var key keys.MasterKey
+		if strings.HasPrefix(recipient, "age1") {
+			key, err = age.MasterKeyFromRecipient(recipient)
+			if err != nil {
+				return nil, fmt.Errorf("invalid age recipient: %s, %w", recipient, err)
+			}
+		} else {
+			key = pgp.NewMasterKeyFromFingerprint(recipient)
+		}
+		group = append(group, key)
+	}
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			KeyGroups:         []sops.KeyGroup{group},
+			UnencryptedSuffix: "_unencrypted",
+			Version:           version.Version,
+		},
+	}
+
+	key, errs := tree.GenerateDataKey()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to encrypt sops data key: %v", errs)
+	}
+	cipher := aes.NewCipher()
+	mac, err := tree.Encrypt(key, cipher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt sops file: %w", err)
+	}
+	tree.Metadata.LastModified = time.Now().UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, key, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt sops mac: %w", err)
+	}
+	return store.EmitEncryptedFile(tree)
+}
+
+func (c SOPSCodec) keyService() (keyservice.KeyServiceServer, error) {
+	service := sopsKeyService{secRing: c.PGPSecRing}
+	if c.AgeKeyFile != "" {
+		b, err := os.ReadFile(c.AgeKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read age key file: %s, %w", c.AgeKeyFile, err)
+		}
+		err = service.identities.Import(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age key file: %s, %w", c.AgeKeyFile, err)
+		}
+	}
+	return service, nil
+}
+
+// sopsKeyService decrypts data keys with the configured age identities and
+// pgp keyring, and the other keys as the sops command does.
+type sopsKeyService struct {
+	keyservice.Server
+	identities age.ParsedIdentities
+	secRing    string
+}
+
+func (s sopsKeyService) Decrypt(ctx context.Context, req *keyservice.DecryptRequest) (*keyservice.DecryptResponse, error) {
+	var plaintext []byte
+	var err error
+	switch k := req.Key.KeyType.(type) {
+	case *keyservice.Key_AgeKey:
+		if len(s.identities) == 0 {
+			return s.Server.Decrypt(ctx, req)
+		}
+		key := &age.MasterKey{Recipient: k.AgeKey.Recipient, EncryptedKey: string(req.Ciphertext)}
+		s.identities.ApplyToMasterKey(key)
+		plaintext, err = key.Decrypt()
+	case *keyservice.Key_PgpKey:
+		if s.secRing == "" {
+			return s.Server.Decrypt(ctx, req)
+		}
+		key := pgp.NewMasterKeyFromFingerprint(k.PgpKey.Fingerprint)
+		key.EncryptedKey = string(req.Ciphertext)
+		pgp.SecRing(s.secRing).ApplyToMasterKey(key)
+		plaintext, err = key.Decrypt()
+	default:
+		return s.Server.Decrypt(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
@@ -0,0 +1,88 @@
+package syncer_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/stretchr/testify/require"
+)
+
+const sopsAgeRecipient = "age10el87lkhfr3hu9pjlzgas2vkw0skx3we47elc4rekukr292ka5es0y3ctz"
+
+func TestSops(t *testing.T) {
+	ctx := context.Background()
+	localPath := "../testdata/dir8"
+
+	// without a key the files can not be decrypted
+	t.Setenv("SOPS_AGE_KEY_FILE", "")
+	_, err := syncer.LoadDesiredState(localPath, "unittest")
+	require.ErrorContains(t, err, "failed to get sops data key")
+
+	t.Setenv("SOPS_AGE_KEY_FILE", "../testdata/sops/age.key")
+	backend := newMemBackend()
+	sync := syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: localPath,
+		CasTry:    3,
+	}, backend)
+	err = sync.Sync(ctx)
+	require.NoError(t, err)
+
+	data, err := backend.ReadData(ctx, "unittest/database")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"username": "admin",
+		"password": "s3cret",
+		"port":     json.Number("5432"),
+	}, data)
+	data, err = backend.ReadData(ctx, "unittest/sub1/api")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"token": "abc123", "enabled": true}, data)
+
+	actions, err := sync.Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+
+	// the key can also be given to the codec
+	t.Setenv("SOPS_AGE_KEY_FILE", "")
+	syncer.RegisterCodec(syncer.SOPSCodec{Codec: syncer.JSONCodec{}, AgeKeyFile: "../testdata/sops/age.key"})
+	syncer.RegisterCodec(syncer.SOPSCodec{Codec: syncer.YAMLCodec{}, AgeKeyFile: "../testdata/sops/age.key"})
+	t.Cleanup(func() {
+		syncer.RegisterCodec(syncer.SOPSCodec{Codec: syncer.JSONCodec{}})
+		syncer.RegisterCodec(syncer.SOPSCodec{Codec: syncer.YAMLCodec{}})
+	})
+	actions, err = sync.Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+
+	syncer.RegisterCodec(syncer.SOPSCodec{
+		Codec:      syncer.YAMLCodec{},
+		AgeKeyFile: "../testdata/sops/age.key",
+		Recipients: []string{sopsAgeRecipient},
+	})
+	fetchPath := t.TempDir()
+	err = syncer.NewFetcherWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: fetchPath,
+		Format:    "yaml",
+	}, backend).Fetch(ctx)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(fetchPath, "database.yaml"))
+	require.NoError(t, err)
+	require.Contains(t, string(b), "recipient: "+sopsAgeRecipient)
+	require.NotContains(t, string(b), "s3cret")
+
+	desired, err := syncer.LoadDesiredState(fetchPath, "unittest")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"username": "admin",
+		"password": "s3cret",
+		"port":     json.Number("5432"),
+	}, desired["unittest/database"].Data)
+	require.Equal(t, map[string]interface{}{"token": "abc123", "enabled": true}, desired["unittest/sub1/api"].Data)
+}
@@ -11,9 +11,7 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/hashicorp/vault-client-go"
 	"github.com/hashicorp/vault-client-go/schema"
@@ -28,10 +26,51 @@ type SyncerConfig struct {
 	CasTry        int
 	VaultRoleId   string
 	VaultSecretId string
+	// KubernetesRole is the role to login with the Kubernetes auth method if
+	// VaultToken is empty, instead of the app role.
+	KubernetesRole string
+	// KubernetesJWTPath is the file of the service account JWT to login with,
+	// defaults to DefaultKubernetesJWTPath.
+	KubernetesJWTPath string
+	// KubernetesMount is the mount of the Kubernetes auth method, defaults to
+	// "kubernetes".
+	KubernetesMount string
+	// Concurrency is the number of keys read and written at a time, defaults
+	// to 1.
+	Concurrency int
+	// KVVersion is the version of the KV secrets engine at MountPath, 1 or 2.
+	// It is detected from the mount if 0.
+	KVVersion int
+	// Format is the format of the files written by Fetch, the first extension
+	// of a registered Codec without the dot, defaults to json.
+	Format string
+	// DeletePolicy is what Sync does with keys whose local files are gone,
+	// defaults to DeletePurge.
+	DeletePolicy DeletePolicy
+	// MaxDeletes is the most keys a sync or apply may delete, 0 for no limit.
+	MaxDeletes int
+	// MaxDeletePercent is the most keys a sync or apply may delete as a
+	// percentage of the keys under VaultPath, 0 for no limit.
+	MaxDeletePercent float64
+	// ForceDelete skips the MaxDeletes and MaxDeletePercent checks.
+	ForceDelete bool
+	// Include and Exclude are glob patterns of keys relative to VaultPath,
+	// where ** matches any number of path segments. Only keys matching a
+	// pattern in Include, if any, and none in Exclude are synced or fetched,
+	// other keys are neither written nor deleted.
+	Include []string
+	Exclude []string
+	// ManagedSource identifies the local files of a sync. If set, keys are
+	// marked as managed by it in their custom metadata when they are written,
+	// and keys without the marker are never deleted.
+	ManagedSource string
 }
 
 type Syncer struct {
 	SyncerConfig
+	backend KVBackend
+	// auth is nil if backend was given
+	auth *vaultAuth
 }
 
 func NewSyncer(config SyncerConfig) *Syncer {
@@ -42,148 +81,212 @@ func NewSyncer(config SyncerConfig) *Syncer {
 	}
 }
 
-func (s *Syncer) Sync(ctx context.Context) error {
-	client, err := vault.New(
-		vault.WithAddress(s.VaultAddr),
-		vault.WithRequestTimeout(30*time.Second),
-		vault.WithEnvironment(),
-	)
+// NewSyncerWithBackend returns a Syncer that writes to backend instead of the
+// vault configured by config.
+func NewSyncerWithBackend(config SyncerConfig, backend KVBackend) *Syncer {
+	s := NewSyncer(config)
+	s.backend = backend
+	return s
+}
+
+func (s *Syncer) kvBackend(ctx context.Context) (KVBackend, error) {
+	if s.backend != nil {
+		return s.backend, nil
+	}
+	backend, auth, err := newBackend(ctx, &s.SyncerConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.backend, s.auth = backend, auth
+	return backend, nil
+}
+
+// newBackend logs in to the vault configured by config and returns its KV
+// secrets engine at MountPath and the login that keeps its token valid.
+func newBackend(ctx context.Context, config *SyncerConfig) (KVBackend, *vaultAuth, error) {
+	auth, err := newVaultAuth(ctx, config)
 	if err != nil {
-		return fmt.Errorf("failed to create vault client: %w", err)
+		return nil, nil, err
 	}
 
-	if s.VaultToken == "" {
-		response, err := client.Auth.AppRoleLogin(ctx, schema.AppRoleLoginRequest{
-			RoleId:   s.VaultRoleId,
-			SecretId: s.VaultSecretId,
-		})
+	if config.KVVersion == 0 {
+		config.KVVersion, err = DetectKVVersion(ctx, auth.client, config.MountPath)
 		if err != nil {
-			return fmt.Errorf("failed to login with app role: %w", err)
+			return nil, nil, err
 		}
-		s.VaultToken = response.Auth.ClientToken
 	}
+	switch config.KVVersion {
+	case 1:
+		return NewKVv1Backend(auth.client, config.MountPath), auth, nil
+	case 2:
+		return NewKVv2Backend(auth.client, config.MountPath), auth, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported kv version: %d", config.KVVersion)
+}
 
-	err = client.SetToken(s.VaultToken)
+func (s *Syncer) Sync(ctx context.Context) error {
+	backend, err := s.kvBackend(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to set vault token: %w", err)
+		return err
 	}
+	_, _, err = s.sync(ctx, backend)
+	return err
+}
 
-	// set or update kv
-	err = filepath.WalkDir(s.LocalPath, func(filePath string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
+// sync syncs LocalPath to backend and returns the desired state it synced and
+// the actions it applied.
+func (s *Syncer) sync(ctx context.Context, backend KVBackend) (DesiredState, []Action, error) {
+	policy, err := s.deletePolicy(backend)
+	if err != nil {
+		return nil, nil, err
+	}
+	desired, err := s.desiredState(backend)
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshot, err := s.snapshot(ctx, backend)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		if d.IsDir() {
-			return nil
-		}
+	err = s.checkDeletes(countDeletes(s.planActions(desired, snapshot, policy)), len(snapshot))
+	if err != nil {
+		return nil, nil, err
+	}
 
-		if !strings.HasSuffix(filePath, ".json") {
-			// skip non-json file
-			return nil
-		}
+	keys := syncKeys(desired, snapshot)
+	applied := make([][]Action, len(keys))
+	err = forEach(ctx, len(keys), s.Concurrency, func(ctx context.Context, i int, logger *log.Logger) error {
+		actions, err := s.syncKey(ctx, backend, keys[i], desired[keys[i]], snapshot[keys[i]], policy, logger)
+		applied[i] = actions
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sync kv: %w", err)
+	}
+	var actions []Action
+	for _, keyActions := range applied {
+		actions = append(actions, keyActions...)
+	}
+	return desired, actions, nil
+}
 
-		if strings.HasSuffix(filePath, ".meta.json") {
-			// skip meta file
-			return nil
-		}
+// desiredState loads the local secrets selected by Include and Exclude.
+// Metadata files are ignored with a warning if backend does not store
+// metadata.
+func (s *Syncer) desiredState(backend KVBackend) (DesiredState, error) {
+	return s.desiredKeys(backend, nil)
+}
 
-		secret, err := ReadLocalSecret(filePath)
-		if err != nil {
-			return fmt.Errorf("failed to read secret file: %s, %w", filePath, err)
+// desiredKeys is desiredState loading only the keys wanted reports true for,
+// or all keys if wanted is nil.
+func (s *Syncer) desiredKeys(backend KVBackend, wanted func(key string) bool) (DesiredState, error) {
+	err := s.checkPatterns()
+	if err != nil {
+		return nil, err
+	}
+	desired, err := loadDesiredState(s.LocalPath, s.VaultPath, func(key string) bool {
+		return s.selected(key) && (wanted == nil || wanted(key))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.ManagedSource != "" && !supportsMetadata(backend) {
+		return nil, fmt.Errorf("managed marker is not supported by the backend")
+	}
+	if supportsMetadata(backend) {
+		return desired, nil
+	}
+	for _, key := range syncKeys(desired, nil) {
+		if desired[key].Metadata != nil {
+			log.Printf("[%s] metadata is not supported by the backend, ignoring metadata file", key)
+			desired[key].Metadata = nil
 		}
+	}
+	return desired, nil
+}
 
-		vaultKey := toVaultKey(s.LocalPath, filePath, s.VaultPath)
-
-		err = setKV(ctx, client, &VaultPair{
-			MountPath: s.MountPath,
-			Key:       vaultKey,
-			Data:      secret.Data,
-			Metadata:  secret.Metadata,
-		}, s.CasTry)
-		if err != nil {
-			return fmt.Errorf("failed to set kv: %s, %w", vaultKey, err)
+// snapshot loads the remote state of VaultPath without the keys of paths
+// ignored by IgnoreFile or not selected by Include and Exclude, so they are
+// never deleted.
+func (s *Syncer) snapshot(ctx context.Context, backend KVBackend) (Snapshot, error) {
+	snapshot, err := LoadSnapshot(ctx, backend, s.VaultPath, s.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	synced, err := s.keyFilter()
+	if err != nil {
+		return nil, err
+	}
+	for key := range snapshot {
+		if !synced(key) {
+			delete(snapshot, key)
 		}
+	}
+	return snapshot, nil
+}
 
-		return nil
-	})
+// keyFilter returns a function that reports whether a key in vault is synced,
+// neither ignored by IgnoreFile nor left out by Include and Exclude.
+func (s *Syncer) keyFilter() (func(key string) bool, error) {
+	ignore, err := loadIgnore(s.LocalPath)
 	if err != nil {
-		return fmt.Errorf("failed to walk local file: %w", err)
+		return nil, fmt.Errorf("failed to load ignore files: %w", err)
 	}
+	return func(key string) bool {
+		return !ignore.ignoredKey(s.relativeKey(key)) && s.selected(key)
+	}, nil
+}
 
-	// delete kv
-	err = WalkKV(ctx, client, s.VaultPath, s.MountPath, func(key string) error {
-		localPath := ToLocalPath(s.LocalPath, s.VaultPath, key)
-		exists, err := FileExists(localPath)
-		if err != nil {
-			return fmt.Errorf("failed to check local file: %s, %w", localPath, err)
-		}
-		metadataPath := toMetadataPath(localPath)
-		metadataExists, err := FileExists(metadataPath)
-		if err != nil {
-			return fmt.Errorf("failed to check metadata file: %s, %w", metadataPath, err)
-		}
-		if exists {
-			// local file exists, skip delete data
-			if !metadataExists {
-				// metadata not exists, clear remote metadata
-				metadataResponse, err := client.Secrets.KvV2ReadMetadata(ctx, key, vault.WithMountPath(s.MountPath))
-				if err != nil {
-					return fmt.Errorf("failed to read metadata: %s, %w", key, err)
-				}
-				if MetadataEqual(&metadataResponse.Data, nil) {
-					// log.Printf("[%s] metadata unchanged", key)
-					return nil
-				}
-				_, err = client.Secrets.KvV2WriteMetadata(ctx, key, schema.KvV2WriteMetadataRequest{
-					CasRequired:        metadataResponse.Data.CasRequired,
-					DeleteVersionAfter: metadataResponse.Data.DeleteVersionAfter,
-					MaxVersions:        int32(metadataResponse.Data.MaxVersions),
-					CustomMetadata: map[string]interface{}{
-						"(empty)": "(empty)",
-					},
-				}, vault.WithMountPath(s.MountPath))
-				if err != nil {
-					return fmt.Errorf("failed to clear metadata: %s, %w", key, err)
-				}
-				log.Printf("[%s] delete metadata success", key)
-				return nil
-			} else {
-				// metadata exists, skip delete data
-				// log.Printf("[%s] skip delete data", key)
-				return nil
-			}
-		} else {
-			// local file not exists, delete remote file
-			_, err = client.Secrets.KvV2Delete(ctx, key, vault.WithMountPath(s.MountPath))
+// syncKey applies the actions that make remote match local and returns them.
+// If that fails, for example because the key changed since the snapshot and the
+// check-and-set failed, the key is read again and planned again, up to CasTry
+// times.
+func (s *Syncer) syncKey(ctx context.Context, backend KVBackend, key string, local *Secret, remote *RemoteKV, policy DeletePolicy, logger *log.Logger) ([]Action, error) {
+	tries := s.CasTry
+	if tries < 1 {
+		tries = 1
+	}
+	var err error
+	for i := 0; i < tries; i++ {
+		if i > 0 {
+			remote, err = readRemoteKV(ctx, backend, key)
 			if err != nil {
-				return fmt.Errorf("failed to delete kv: %s, %w", key, err)
+				return nil, fmt.Errorf("failed to read remote kv: %s, %w", key, err)
 			}
-			_, err = client.Secrets.KvV2DeleteMetadataAndAllVersions(ctx, key, vault.WithMountPath(s.MountPath))
-			if err != nil {
-				return fmt.Errorf("failed to delete metadata: %s, %w", key, err)
-			}
-			log.Printf("[%s] delete data and metadata success", key)
 		}
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("failed to walk vault file: %w", err)
-	}
 
-	return nil
+		actions := s.planKey(key, local, remote, policy)
+		if len(actions) == 0 {
+			logger.Printf("[%s] unchanged", key)
+			return nil, nil
+		}
+		err = applyActions(ctx, backend, actions, logger)
+		if err == nil {
+			return actions, nil
+		}
+		logger.Printf("[%s] sync kv failed, try %d: %+v", key, i+1, err)
+	}
+	return nil, fmt.Errorf("failed to sync kv after %d tries: %s, %w", tries, key, err)
 }
 
+// WalkKV calls walkFn for every key under vaultPath in the KV v2 secrets
+// engine at mountPath, see WalkBackend.
 func WalkKV(ctx context.Context, client *vault.Client, vaultPath string, mountPath string, walkFn func(key string) error) error {
-	response, err := client.Secrets.KvV2List(ctx, vaultPath, vault.WithMountPath(mountPath))
+	return WalkBackend(ctx, NewKVv2Backend(client, mountPath), vaultPath, walkFn)
+}
+
+// WalkBackend calls walkFn for every key under vaultPath in backend.
+func WalkBackend(ctx context.Context, backend KVBackend, vaultPath string, walkFn func(key string) error) error {
+	names, err := backend.List(ctx, vaultPath)
 	if err != nil {
 		return fmt.Errorf("failed to list kv: %s, %w", vaultPath, err)
 	}
 
-	for _, key := range response.Data.Keys {
+	for _, key := range names {
 		if strings.HasSuffix(key, "/") {
 			// is a directory
-			err := WalkKV(ctx, client, path.Join(vaultPath, key), mountPath, walkFn)
+			err := WalkBackend(ctx, backend, path.Join(vaultPath, key), walkFn)
 			if err != nil {
 				return fmt.Errorf("failed to walk kv: %s, %w", path.Join(vaultPath, key), err)
 			}
@@ -198,22 +301,79 @@ func WalkKV(ctx context.Context, client *vault.Client, vaultPath string, mountPa
 	return nil
 }
 
+// walkLocal calls walkFn for every secret file under localPath, metadata files,
+// files without a secret extension and paths ignored by IgnoreFile are skipped.
+func walkLocal(localPath string, walkFn func(filePath string) error) error {
+	ignore, err := loadIgnore(localPath)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(localPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(localPath, filePath)
+		if err != nil {
+			return err
+		}
+		if ignore.ignored(filepath.ToSlash(rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if secretExt(filePath) == "" {
+			// skip meta file and non-secret file
+			return nil
+		}
+
+		return walkFn(filePath)
+	})
+}
+
+// localFiles returns the secret files under localPath in walk order.
+func localFiles(localPath string) ([]string, error) {
+	var files []string
+	err := walkLocal(localPath, func(filePath string) error {
+		files = append(files, filePath)
+		return nil
+	})
+	return files, err
+}
+
 func toVaultKey(prefix, localPath, vaultPath string) string {
 	relativePath := strings.TrimPrefix(localPath, prefix)
 	targetPath := path.Join(vaultPath, relativePath)
 	targetPath = strings.TrimPrefix(targetPath, "/")
-	targetPath = strings.TrimSuffix(targetPath, ".json")
+	targetPath = strings.TrimSuffix(targetPath, secretExt(targetPath))
 	return targetPath
 }
 
 func toMetadataPath(path string) string {
-	path = strings.TrimSuffix(path, ".json")
-	return path + ".meta.json"
+	codec, ext := lookupCodec(path)
+	if ext == "" {
+		ext = ".json"
+	}
+	path = strings.TrimSuffix(path, ext)
+	if c, ok := codec.(metadataExtensioner); ok {
+		ext = c.MetadataExtension(ext)
+	}
+	return path + ".meta" + ext
 }
 
 func ToLocalPath(localPath, vaultPath, key string) string {
+	return toLocalPath(localPath, vaultPath, key, ".json")
+}
+
+func toLocalPath(localPath, vaultPath, key, ext string) string {
 	relativePath := strings.TrimPrefix(key, vaultPath)
-	return path.Join(localPath, relativePath) + ".json"
+	return path.Join(localPath, relativePath) + ext
 }
 
 func ReadJson(file string, v interface{}) error {
@@ -223,7 +383,9 @@ func ReadJson(file string, v interface{}) error {
 	}
 	defer f.Close()
 
-	err = json.NewDecoder(f).Decode(v)
+	decoder := json.NewDecoder(f)
+	decoder.UseNumber()
+	err = decoder.Decode(v)
 	if err != nil {
 		return fmt.Errorf("failed to decode json: %s, %w", file, err)
 	}
@@ -256,21 +418,40 @@ type Secret struct {
 }
 
 func ReadData(file string) (map[string]interface{}, error) {
-	var data = make(map[string]interface{})
-	err := ReadJson(file, &data)
+	codec, b, err := readCodecFile(file)
 	if err != nil {
 		return nil, err
 	}
+	data, err := codec.DecodeData(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode data: %s, %w", file, err)
+	}
 	return data, nil
 }
 
 func ReadMetadata(file string) (*schema.KvV2WriteMetadataRequest, error) {
-	var metadata schema.KvV2WriteMetadataRequest
-	err := ReadJson(file, &metadata)
+	codec, b, err := readCodecFile(file)
 	if err != nil {
 		return nil, err
 	}
-	return &metadata, nil
+	metadata, err := codec.DecodeMetadata(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %s, %w", file, err)
+	}
+	return metadata, nil
+}
+
+// readCodecFile reads file and returns it with the codec of its extension.
+func readCodecFile(file string) (Codec, []byte, error) {
+	codec, _ := lookupCodec(file)
+	if codec == nil {
+		return nil, nil, fmt.Errorf("unsupported file format: %s", file)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file: %s, %w", file, err)
+	}
+	return codec, b, nil
 }
 
 func ReadLocalSecret(file string) (*Secret, error) {
@@ -298,213 +479,18 @@ func ReadLocalSecret(file string) (*Secret, error) {
 	}, nil
 }
 
-type VaultPair struct {
-	MountPath string
-	Key       string
-	Data      map[string]interface{} `json:"data,omitempty"`
-	Metadata  *schema.KvV2WriteMetadataRequest
-}
-
-func setKV(ctx context.Context, client *vault.Client, pair *VaultPair, casTry int) error {
-	for i := 0; i < casTry; i++ {
-		err := trySetKV(ctx, client, pair)
-		if err != nil {
-			log.Printf("[%s] set kv failed, try %d: %+v", pair.Key, i+1, err)
-			continue
-		}
-		return nil
-	}
-	return fmt.Errorf("failed to set kv after %d tries", casTry)
-}
-
-// POST https://vault-test.answeraiops.com/v1/kv/data/chatbot/admin/test
-//
-//	{
-//		"data": {
-//		  "test": "test"
-//		},
-//		"options": {
-//		  "cas": 0
-//		}
-//	  }
-//
-// Response:
-//
-//	{
-//	    "request_id": "ea63c36f-a83b-8b4c-fc9b-0a4173604cd7",
-//	    "lease_id": "",
-//	    "renewable": false,
-//	    "lease_duration": 0,
-//	    "data": {
-//	        "created_time": "2024-12-24T11:36:20.976596673Z",
-//	        "custom_metadata": null,
-//	        "deletion_time": "",
-//	        "destroyed": false,
-//	        "version": 1
-//	    },
-//	    "wrap_info": null,
-//	    "warnings": null,
-//	    "auth": null,
-//	    "mount_type": "kv"
-//	}
-
-// POST https://vault-test.answeraiops.com/v1/kv/metadata/chatbot/admin/test
-//
-//	{
-//		"max_versions": 0,
-//		"cas_required": true,
-//		"delete_version_after": "0s",
-//		"custom_metadata": {
-//		  "test": "test"
-//		}
-//	  }
-
-// DELETE https://vault-test.answeraiops.com/v1/kv/metadata/chatbot/admin/test
-func trySetKV(ctx context.Context, client *vault.Client, pair *VaultPair) error {
-	err := trySetData(ctx, client, pair)
-	if err != nil {
-		return fmt.Errorf("failed to set data: %w", err)
-	}
-	err = trySetMetadata(ctx, client, pair)
-	if err != nil {
-		return fmt.Errorf("failed to set metadata: %w", err)
-	}
-	return nil
-}
-
-func trySetData(ctx context.Context, client *vault.Client, pair *VaultPair) error {
-	response, err := client.Secrets.KvV2Read(ctx, pair.Key, vault.WithMountPath(pair.MountPath))
-	if err != nil {
-		var responseError *vault.ResponseError
-		if errors.As(err, &responseError) {
-			if responseError.StatusCode == 404 {
-				// not found
-			} else {
-				return fmt.Errorf("failed to read kv: %+v", responseError)
-			}
-		} else {
-			return fmt.Errorf("failed to read kv: %w", err)
-		}
-	}
-
-	if response == nil { // not found
-		writeResponse, err := client.Secrets.KvV2Write(ctx, pair.Key, schema.KvV2WriteRequest{
-			Data: pair.Data,
-			Options: map[string]interface{}{
-				"cas": 0,
-			},
-		}, vault.WithMountPath(pair.MountPath))
-		if err != nil {
-			return fmt.Errorf("failed to create data: %w", err)
-		}
-
-		log.Printf("[%s] create success (%d)", pair.Key, writeResponse.Data.Version)
-		return nil
-	}
-
-	if MapEqual(response.Data.Data, pair.Data) {
-		log.Printf("[%s] data unchanged", pair.Key)
-		return nil
-	}
-
-	version, err := getVersion(response.Data.Metadata)
-	if err != nil {
-		return fmt.Errorf("failed to get version: %w", err)
-	}
-
-	writeResponse, err := client.Secrets.KvV2Write(ctx, pair.Key, schema.KvV2WriteRequest{
-		Data: pair.Data,
-		Options: map[string]interface{}{
-			"cas": version,
+// clearMetadataRequest keeps the settings of metadata but clears its custom
+// metadata. Vault ignores an empty custom_metadata, so a placeholder is written
+// instead, see IsEmptyMap.
+func clearMetadataRequest(metadata *schema.KvV2ReadMetadataResponse) schema.KvV2WriteMetadataRequest {
+	return schema.KvV2WriteMetadataRequest{
+		CasRequired:        metadata.CasRequired,
+		DeleteVersionAfter: metadata.DeleteVersionAfter,
+		MaxVersions:        int32(metadata.MaxVersions),
+		CustomMetadata: map[string]interface{}{
+			"(empty)": "(empty)",
 		},
-	}, vault.WithMountPath(pair.MountPath))
-	if err != nil {
-		return fmt.Errorf("failed to update data: %w", err)
-	}
-
-	log.Printf("[%s] update success (%d)", pair.Key, writeResponse.Data.Version)
-	return nil
-}
-
-func trySetMetadata(ctx context.Context, client *vault.Client, pair *VaultPair) error {
-	var notFound bool
-	metadataResponse, err := client.Secrets.KvV2ReadMetadata(ctx, pair.Key, vault.WithMountPath(pair.MountPath))
-	if err != nil {
-		var notFoundError *vault.ResponseError
-		if errors.As(err, &notFoundError) {
-			if notFoundError.StatusCode == 404 {
-				// not found
-				notFound = true
-			} else {
-				return fmt.Errorf("failed to read metadata: %w", err)
-			}
-		} else {
-			return fmt.Errorf("failed to read metadata: %w", err)
-		}
-	}
-
-	if notFound {
-		if pair.Metadata == nil {
-			// not found and not set
-			log.Printf("[%s] metadata not found and not set", pair.Key)
-			return nil
-		}
-		// not found and set
-		_, err = client.Secrets.KvV2WriteMetadata(ctx, pair.Key, *pair.Metadata, vault.WithMountPath(pair.MountPath))
-		if err != nil {
-			return fmt.Errorf("failed to create metadata: %w", err)
-		}
-		log.Printf("[%s] create metadata success", pair.Key)
-		return nil
-	}
-
-	if MetadataEqual(&metadataResponse.Data, pair.Metadata) {
-		log.Printf("[%s] metadata unchanged", pair.Key)
-		return nil
-	}
-
-	if pair.Metadata == nil {
-		// remove custom_metadata
-		_, err = client.Secrets.KvV2WriteMetadata(ctx, pair.Key, schema.KvV2WriteMetadataRequest{
-			CasRequired:        metadataResponse.Data.CasRequired,
-			DeleteVersionAfter: metadataResponse.Data.DeleteVersionAfter,
-			MaxVersions:        int32(metadataResponse.Data.MaxVersions),
-			CustomMetadata: map[string]interface{}{
-				"(empty)": "(empty)",
-			},
-		}, vault.WithMountPath(pair.MountPath))
-		if err != nil {
-			return fmt.Errorf("failed to clear metadata: %w", err)
-		}
-		log.Printf("[%s] clear metadata success", pair.Key)
-
-		logMetadata(ctx, client, pair)
-		return nil
 	}
-
-	_, err = client.Secrets.KvV2WriteMetadata(ctx, pair.Key, *pair.Metadata, vault.WithMountPath(pair.MountPath))
-	if err != nil {
-		return fmt.Errorf("failed to update metadata: %w", err)
-	}
-	log.Printf("[%s] update metadata success", pair.Key)
-	logMetadata(ctx, client, pair)
-	return nil
-}
-
-func logMetadata(ctx context.Context, client *vault.Client, pair *VaultPair) {
-	// metadataResponse, err := client.Secrets.KvV2ReadMetadata(ctx, pair.Key, vault.WithMountPath(pair.MountPath))
-	// if err != nil {
-	// 	log.Printf("[%s] read metadata failed: %+v", pair.Key, err)
-	// 	return
-	// }
-	// log.Printf("[%s] read metadata success (%+v) expect %+v", pair.Key, metadataResponse.Data, pair.Metadata)
-
-	// response, err := client.Secrets.KvV2Read(ctx, pair.Key, vault.WithMountPath(pair.MountPath))
-	// if err != nil {
-	// 	log.Printf("[%s] read data failed: %+v", pair.Key, err)
-	// 	return
-	// }
-	// log.Printf("[%s] read data success (%+v)", pair.Key, response.Data.Metadata)
 }
 
 func MetadataEqual(a *schema.KvV2ReadMetadataResponse, b *schema.KvV2WriteMetadataRequest) bool {
@@ -527,26 +513,8 @@ func MetadataEqual(a *schema.KvV2ReadMetadataResponse, b *schema.KvV2WriteMetada
 	return MapEqual(a.CustomMetadata, b.CustomMetadata)
 }
 
-func getVersion(metadata map[string]interface{}) (int, error) {
-	version, ok := metadata["version"]
-	if !ok {
-		return 0, fmt.Errorf("version not found")
-	}
-	switch v := version.(type) {
-	case int:
-		return v, nil
-	case json.Number:
-		if i, err := v.Int64(); err == nil {
-			return int(i), nil
-		}
-		if f, err := v.Float64(); err == nil {
-			return int(f), nil
-		}
-		return 0, fmt.Errorf("version is not a number")
-	case string:
-		return strconv.Atoi(v)
-	}
-	return 0, fmt.Errorf("version is not a number")
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
 }
 
 func FileExists(file string) (bool, error) {
@@ -1,12 +1,18 @@
 package syncer_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/WqyJh/consul-vault-conf/test"
 	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/WqyJh/vaultsync/syncer/vaulttest"
 	"github.com/hashicorp/vault-client-go"
 	"github.com/hashicorp/vault-client-go/schema"
 	"github.com/stretchr/testify/require"
@@ -14,27 +20,7 @@ import (
 
 func TestSync(t *testing.T) {
 	ctx := context.Background()
-	vaultServer, err := test.SetupVaultServer(ctx, test.VaultConfig{
-		Policies: []test.VaultPolicy{
-			{
-				Name: "unittest-write",
-				Policy: schema.PoliciesWriteAclPolicyRequest{
-					Policy: `path "kv/data/unittest/*" {
-						policy = "write"
-					}`,
-				},
-			},
-		},
-		AppRoles: []test.VaultAppRole{
-			{
-				Name: "unittest",
-				TokenRules: schema.AppRoleWriteRoleRequest{
-					TokenPolicies: []string{"unittest-write"},
-				},
-			},
-		},
-	})
-	require.NoError(t, err)
+	vaultServer := vaulttest.NewServer(vaulttest.Config{})
 	defer vaultServer.Stop()
 
 	for i := 0; i < 3; i++ {
@@ -46,7 +32,7 @@ func TestSync(t *testing.T) {
 			LocalPath:  "../testdata/dir1",
 			CasTry:     3,
 		})
-		err = sync.Sync(ctx)
+		err := sync.Sync(ctx)
 		require.NoError(t, err)
 		t.Logf("sync dir1 success")
 
@@ -142,27 +128,7 @@ func TestSync(t *testing.T) {
 
 func TestVault(t *testing.T) {
 	ctx := context.Background()
-	vaultServer, err := test.SetupVaultServer(ctx, test.VaultConfig{
-		Policies: []test.VaultPolicy{
-			{
-				Name: "unittest-write",
-				Policy: schema.PoliciesWriteAclPolicyRequest{
-					Policy: `path "kv/data/unittest/*" {
-						policy = "write"
-					}`,
-				},
-			},
-		},
-		AppRoles: []test.VaultAppRole{
-			{
-				Name: "unittest",
-				TokenRules: schema.AppRoleWriteRoleRequest{
-					TokenPolicies: []string{"unittest-write"},
-				},
-			},
-		},
-	})
-	require.NoError(t, err)
+	vaultServer := vaulttest.NewServer(vaulttest.Config{})
 	defer vaultServer.Stop()
 
 	client, err := vault.New(
@@ -340,3 +306,293 @@ func TestVault(t *testing.T) {
 		"(empty)": "(empty)",
 	}, metadataResponse.Data.CustomMetadata)
 }
+
+func TestSyncConcurrency(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{})
+	defer vaultServer.Stop()
+
+	// NewSyncer takes local path relative to the working directory
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	localPath, err := filepath.Rel(wd, t.TempDir())
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		dir := filepath.Join(localPath, fmt.Sprintf("dir_%d", i%5))
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("secret_%02d.json", i)), []byte(fmt.Sprintf(`{"key": "value_%d"}`, i)), 0644)
+		require.NoError(t, err)
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	sync := syncer.NewSyncer(syncer.SyncerConfig{
+		VaultAddr:   vaultServer.VaultAddr,
+		VaultToken:  vaultServer.RootToken,
+		MountPath:   "kv",
+		VaultPath:   "unittest",
+		LocalPath:   localPath,
+		CasTry:      3,
+		Concurrency: 8,
+	})
+	err = sync.Sync(ctx)
+	require.NoError(t, err)
+
+	// logs are in walk order
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 50)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("unittest/dir_%d/secret_%02d", (i/10)%5, (i/10)+(i%10)*5)
+		require.Equal(t, fmt.Sprintf("[%s] create success (1)", key), lines[i])
+	}
+
+	fetchPath := t.TempDir()
+	fetcher := syncer.NewFetcher(syncer.SyncerConfig{
+		VaultAddr:   vaultServer.VaultAddr,
+		VaultToken:  vaultServer.RootToken,
+		MountPath:   "kv",
+		VaultPath:   "unittest",
+		LocalPath:   fetchPath,
+		CasTry:      3,
+		Concurrency: 8,
+	})
+	err = fetcher.Fetch(ctx)
+	require.NoError(t, err)
+	directoryEqual(t, localPath, fetchPath)
+
+	// remove half of the files
+	for i := 0; i < 50; i += 2 {
+		err := os.Remove(filepath.Join(localPath, fmt.Sprintf("dir_%d", i%5), fmt.Sprintf("secret_%02d.json", i)))
+		require.NoError(t, err)
+	}
+	logs.Reset()
+	err = sync.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 25, strings.Count(logs.String(), "delete data and metadata success"))
+
+	actions, err := sync.Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+}
+
+func TestSyncKVv1(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{
+		KVv1Mounts: []string{"secret"},
+	})
+	defer vaultServer.Stop()
+
+	client, err := vault.New(vault.WithAddress(vaultServer.VaultAddr))
+	require.NoError(t, err)
+	err = client.SetToken(vaultServer.RootToken)
+	require.NoError(t, err)
+	version, err := syncer.DetectKVVersion(ctx, client, "secret")
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+	version, err = syncer.DetectKVVersion(ctx, client, "kv")
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+	_, err = syncer.DetectKVVersion(ctx, client, "missing")
+	require.ErrorContains(t, err, "failed to read mount: missing")
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	for _, localPath := range []string{"../testdata/dir1", "../testdata/dir2"} {
+		sync := syncer.NewSyncer(syncer.SyncerConfig{
+			VaultAddr:  vaultServer.VaultAddr,
+			VaultToken: vaultServer.RootToken,
+			MountPath:  "secret",
+			VaultPath:  "unittest",
+			LocalPath:  localPath,
+			CasTry:     3,
+		})
+		err = sync.Sync(ctx)
+		require.NoError(t, err)
+
+		actions, err := sync.Plan(ctx)
+		require.NoError(t, err)
+		require.Empty(t, actions)
+	}
+	require.Contains(t, logs.String(), "[unittest/config_1] metadata is not supported by the backend, ignoring metadata file")
+
+	response, err := client.Secrets.KvV1List(ctx, "unittest", vault.WithMountPath("secret"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"config_1", "config_3"}, response.Data.Keys)
+
+	fetchPath := t.TempDir()
+	fetcher := syncer.NewFetcher(syncer.SyncerConfig{
+		VaultAddr:  vaultServer.VaultAddr,
+		VaultToken: vaultServer.RootToken,
+		MountPath:  "secret",
+		VaultPath:  "unittest",
+		LocalPath:  fetchPath,
+		KVVersion:  1,
+	})
+	err = fetcher.Fetch(ctx)
+	require.NoError(t, err)
+	directoryEqual(t, "../testdata/dir2", fetchPath)
+
+	// without versions, apply compares the data
+	sync := syncer.NewSyncer(syncer.SyncerConfig{
+		VaultAddr:  vaultServer.VaultAddr,
+		VaultToken: vaultServer.RootToken,
+		MountPath:  "secret",
+		VaultPath:  "unittest",
+		LocalPath:  "../testdata/dir1",
+		CasTry:     3,
+	})
+	actions, err := sync.Plan(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, actions)
+	_, err = client.Secrets.KvV1Write(ctx, "unittest/config_3", map[string]interface{}{
+		"key3": "changed",
+	}, vault.WithMountPath("secret"))
+	require.NoError(t, err)
+	err = sync.Apply(ctx, actions)
+	require.ErrorIs(t, err, syncer.ErrStalePlan)
+
+	actions, err = sync.Plan(ctx)
+	require.NoError(t, err)
+	err = sync.Apply(ctx, actions)
+	require.NoError(t, err)
+	actions, err = sync.Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+}
+
+func TestDeletePolicy(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{
+		KVv1Mounts: []string{"secret"},
+	})
+	defer vaultServer.Stop()
+
+	client, err := vault.New(vault.WithAddress(vaultServer.VaultAddr))
+	require.NoError(t, err)
+	err = client.SetToken(vaultServer.RootToken)
+	require.NoError(t, err)
+
+	newSyncer := func(localPath string, policy syncer.DeletePolicy) *syncer.Syncer {
+		return syncer.NewSyncer(syncer.SyncerConfig{
+			VaultAddr:    vaultServer.VaultAddr,
+			VaultToken:   vaultServer.RootToken,
+			MountPath:    "kv",
+			VaultPath:    "unittest",
+			LocalPath:    localPath,
+			CasTry:       3,
+			DeletePolicy: policy,
+		})
+	}
+	err = newSyncer("../testdata/dir1", "").Sync(ctx)
+	require.NoError(t, err)
+
+	// retain keeps the keys and reports them
+	actions, err := newSyncer("../testdata/dir2", syncer.DeleteRetain).Plan(ctx)
+	require.NoError(t, err)
+	require.Contains(t, actions, syncer.Action{Type: syncer.ActionOrphan, Key: "unittest/config_2", Version: 1})
+	err = newSyncer("../testdata/dir2", syncer.DeleteRetain).Sync(ctx)
+	require.NoError(t, err)
+	_, err = client.Secrets.KvV2Read(ctx, "unittest/config_2", vault.WithMountPath("kv"))
+	require.NoError(t, err)
+
+	// soft deletes the current versions and keeps the history
+	err = newSyncer("../testdata/dir2", syncer.DeleteSoft).Sync(ctx)
+	require.NoError(t, err)
+	_, err = client.Secrets.KvV2Read(ctx, "unittest/config_2", vault.WithMountPath("kv"))
+	require.True(t, vault.IsErrorStatus(err, 404))
+	metadata, err := client.Secrets.KvV2ReadMetadata(ctx, "unittest/config_2", vault.WithMountPath("kv"))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), metadata.Data.CurrentVersion)
+	actions, err = newSyncer("../testdata/dir2", syncer.DeleteSoft).Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+
+	// purge removes the history too
+	err = newSyncer("../testdata/dir2", syncer.DeletePurge).Sync(ctx)
+	require.NoError(t, err)
+	_, err = client.Secrets.KvV2ReadMetadata(ctx, "unittest/config_2", vault.WithMountPath("kv"))
+	require.True(t, vault.IsErrorStatus(err, 404))
+
+	err = newSyncer("../testdata/dir2", "keep").Sync(ctx)
+	require.ErrorContains(t, err, "unknown delete policy: keep")
+
+	sync := syncer.NewSyncer(syncer.SyncerConfig{
+		VaultAddr:    vaultServer.VaultAddr,
+		VaultToken:   vaultServer.RootToken,
+		MountPath:    "secret",
+		VaultPath:    "unittest",
+		LocalPath:    "../testdata/dir2",
+		DeletePolicy: syncer.DeleteSoft,
+	})
+	err = sync.Sync(ctx)
+	require.ErrorContains(t, err, "soft delete is not supported by the backend")
+}
+
+func TestMaxDeletes(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemBackend()
+	newSyncer := func(localPath string, config syncer.SyncerConfig) *syncer.Syncer {
+		config.VaultPath = "unittest"
+		config.LocalPath = localPath
+		config.CasTry = 3
+		return syncer.NewSyncerWithBackend(config, backend)
+	}
+	err := newSyncer("../testdata/dir1", syncer.SyncerConfig{}).Sync(ctx)
+	require.NoError(t, err)
+
+	// a typo in the local path points to an empty directory
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	emptyPath, err := filepath.Rel(wd, t.TempDir())
+	require.NoError(t, err)
+	err = newSyncer(emptyPath, syncer.SyncerConfig{MaxDeletePercent: 50}).Sync(ctx)
+	require.ErrorIs(t, err, syncer.ErrTooManyDeletes)
+	require.ErrorContains(t, err, "3 of 3 keys would be deleted, more than the limit of 50%")
+	err = newSyncer(emptyPath, syncer.SyncerConfig{MaxDeletes: 2}).Sync(ctx)
+	require.ErrorContains(t, err, "3 keys would be deleted, more than the limit of 2")
+	_, err = backend.ReadData(ctx, "unittest/config_1")
+	require.NoError(t, err)
+
+	// retained keys are not deleted
+	err = newSyncer(emptyPath, syncer.SyncerConfig{MaxDeletes: 2, DeletePolicy: syncer.DeleteRetain}).Sync(ctx)
+	require.NoError(t, err)
+
+	// within the limits
+	err = newSyncer("../testdata/dir2", syncer.SyncerConfig{MaxDeletes: 2, MaxDeletePercent: 70}).Sync(ctx)
+	require.NoError(t, err)
+	_, err = backend.ReadData(ctx, "unittest/config_2")
+	require.Error(t, err)
+
+	// plans are checked when they are applied
+	actions, err := newSyncer(emptyPath, syncer.SyncerConfig{MaxDeletes: 1}).Plan(ctx)
+	require.NoError(t, err)
+	err = newSyncer(emptyPath, syncer.SyncerConfig{MaxDeletes: 1}).Apply(ctx, actions)
+	require.ErrorIs(t, err, syncer.ErrTooManyDeletes)
+	err = newSyncer(emptyPath, syncer.SyncerConfig{MaxDeletes: 1, ForceDelete: true}).Apply(ctx, actions)
+	require.NoError(t, err)
+	_, err = backend.ReadData(ctx, "unittest/config_3")
+	require.Error(t, err)
+
+	// the percentage is of the keys selected to sync, in a sync and an apply
+	err = newSyncer("../testdata/dir1", syncer.SyncerConfig{}).Sync(ctx)
+	require.NoError(t, err)
+	for _, key := range []string{"other/a", "other/b", "other/c"} {
+		_, err = backend.WriteData(ctx, "unittest/"+key, map[string]interface{}{"key": "value"}, 0)
+		require.NoError(t, err)
+	}
+	config := syncer.SyncerConfig{Exclude: []string{"other/**"}, MaxDeletePercent: 60}
+	err = newSyncer(emptyPath, config).Sync(ctx)
+	require.ErrorContains(t, err, "3 of 3 keys would be deleted")
+	actions, err = newSyncer(emptyPath, config).Plan(ctx)
+	require.NoError(t, err)
+	err = newSyncer(emptyPath, config).Apply(ctx, actions)
+	require.ErrorContains(t, err, "3 of 3 keys would be deleted")
+}
@@ -0,0 +1,97 @@
+package syncer
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+// TOMLCodec reads and writes .toml files. Tables are nested maps, integers,
+// floats and booleans keep their types and dates and times are strings in
+// their toml form.
+type TOMLCodec struct{}
+
+func (TOMLCodec) Extensions() []string {
+	return []string{".toml"}
+}
+
+func (TOMLCodec) DecodeData(b []byte) (map[string]interface{}, error) {
+	var data = make(map[string]interface{})
+	err := decodeToml(b, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (TOMLCodec) DecodeMetadata(b []byte) (*schema.KvV2WriteMetadataRequest, error) {
+	var metadata schema.KvV2WriteMetadataRequest
+	err := decodeToml(b, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+func (TOMLCodec) Encode(v interface{}) ([]byte, error) {
+	value, err := toJsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := toml.NewEncoder(&buf)
+	encoder.Indent = ""
+	err = encoder.Encode(fromJsonNumbers(value))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeToml decodes toml into v through json, so that json tags apply and
+// numbers are the same as in json files.
+func decodeToml(b []byte, v interface{}) error {
+	var value map[string]interface{}
+	err := toml.Unmarshal(b, &value)
+	if err != nil {
+		return err
+	}
+	b, err = json.Marshal(tomlDatesToStrings(value))
+	if err != nil {
+		return err
+	}
+	return decodeJson(b, v)
+}
+
+// tomlDatesToStrings replaces the dates and times in v with strings formatted
+// as in toml, so that local dates stay dates rather than become timestamps.
+func tomlDatesToStrings(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		switch v.Location().String() {
+		case "datetime-local":
+			return v.Format("2006-01-02T15:04:05.999999999")
+		case "date-local":
+			return v.Format("2006-01-02")
+		case "time-local":
+			return v.Format("15:04:05.999999999")
+		}
+		return v.Format(time.RFC3339Nano)
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = tomlDatesToStrings(value)
+		}
+	case []map[string]interface{}:
+		for _, value := range v {
+			tomlDatesToStrings(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = tomlDatesToStrings(value)
+		}
+	}
+	return v
+}
@@ -0,0 +1,81 @@
+package syncer_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/WqyJh/vaultsync/syncer/vaulttest"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToml(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{})
+	defer vaultServer.Stop()
+
+	sync := syncer.NewSyncer(syncer.SyncerConfig{
+		VaultAddr:  vaultServer.VaultAddr,
+		VaultToken: vaultServer.RootToken,
+		MountPath:  "kv",
+		VaultPath:  "unittest",
+		LocalPath:  "../testdata/dir7",
+		CasTry:     3,
+	})
+	err := sync.Sync(ctx)
+	require.NoError(t, err)
+
+	actions, err := sync.Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+
+	client, err := vault.New(vault.WithAddress(vaultServer.VaultAddr))
+	require.NoError(t, err)
+	err = client.SetToken(vaultServer.RootToken)
+	require.NoError(t, err)
+	response, err := client.Secrets.KvV2Read(ctx, "unittest/service", vault.WithMountPath("kv"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"name":     "billing",
+		"port":     json.Number("8443"),
+		"ratio":    json.Number("0.75"),
+		"debug":    false,
+		"released": "2024-05-27",
+		"updated":  "2024-05-27T07:32:00Z",
+		"hosts":    []interface{}{"a.example.com", "b.example.com"},
+		"database": map[string]interface{}{
+			"user":     "billing",
+			"password": "s3cret",
+			"pool": map[string]interface{}{
+				"max": json.Number("20"),
+			},
+		},
+	}, response.Data.Data)
+	metadataResponse, err := client.Secrets.KvV2ReadMetadata(ctx, "unittest/service", vault.WithMountPath("kv"))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), metadataResponse.Data.MaxVersions)
+	require.Equal(t, map[string]interface{}{"owner": "payments"}, metadataResponse.Data.CustomMetadata)
+
+	fetchPath := t.TempDir()
+	fetcher := syncer.NewFetcher(syncer.SyncerConfig{
+		VaultAddr:  vaultServer.VaultAddr,
+		VaultToken: vaultServer.RootToken,
+		MountPath:  "kv",
+		VaultPath:  "unittest",
+		LocalPath:  fetchPath,
+		Format:     "toml",
+	})
+	err = fetcher.Fetch(ctx)
+	require.NoError(t, err)
+
+	fileEqual(t, "../testdata/dir7/service.toml", filepath.Join(fetchPath, "service.toml"), false)
+	fileEqual(t, "../testdata/dir7/service.meta.toml", filepath.Join(fetchPath, "service.meta.toml"), true)
+	b, err := os.ReadFile(filepath.Join(fetchPath, "service.toml"))
+	require.NoError(t, err)
+	require.Contains(t, string(b), "port = 8443\n")
+	require.Contains(t, string(b), "[database.pool]\nmax = 20\n")
+}
@@ -0,0 +1,47 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Validate reads the local files under LocalPath like Sync does, without
+// talking to vault. It returns the keys of the files that can be synced and
+// the errors of all files that can not.
+func (s *Syncer) Validate() ([]string, error) {
+	err := s.checkPatterns()
+	if err != nil {
+		return nil, err
+	}
+	files, err := localFiles(s.LocalPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk local file: %w", err)
+	}
+
+	var (
+		keys     []string
+		errs     []error
+		keyFiles = make(map[string]string, len(files))
+	)
+	for _, file := range files {
+		key := toVaultKey(s.LocalPath, file, s.VaultPath)
+		if !s.selected(key) {
+			continue
+		}
+		if other, ok := keyFiles[key]; ok {
+			errs = append(errs, fmt.Errorf("secret files map to the same key: %s, %s and %s", key, other, file))
+			continue
+		}
+		keyFiles[key] = file
+		_, err := ReadLocalSecret(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys, errors.Join(errs...)
+}
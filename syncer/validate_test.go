@@ -0,0 +1,43 @@
+package syncer_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	keys, err := syncer.NewSyncer(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: "../testdata/dir1",
+	}).Validate()
+	require.NoError(t, err)
+	require.Equal(t, []string{"unittest/config_1", "unittest/config_2", "unittest/sub1/secret_1"}, keys)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	localPath, err := filepath.Rel(wd, t.TempDir())
+	require.NoError(t, err)
+	for name, content := range map[string]string{
+		"valid.json":    `{"key": "value"}`,
+		"broken.json":   `{"key": `,
+		"config.json":   `{"key": "value"}`,
+		"config.yaml":   `key: value`,
+		"app.json":      `{"key": "value"}`,
+		"app.meta.json": `{"max_versions": "many"}`,
+	} {
+		err := os.WriteFile(filepath.Join(localPath, name), []byte(content), 0644)
+		require.NoError(t, err)
+	}
+	keys, err = syncer.NewSyncer(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: localPath,
+	}).Validate()
+	require.Equal(t, []string{"unittest/config", "unittest/valid"}, keys)
+	require.ErrorContains(t, err, "broken.json")
+	require.ErrorContains(t, err, "app.meta.json")
+	require.ErrorContains(t, err, "secret files map to the same key: unittest/config")
+}
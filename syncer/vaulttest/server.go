@@ -0,0 +1,695 @@
+// Package vaulttest provides an in-process fake of the Vault HTTP API that is
+// good enough to exercise syncer.Syncer and syncer.Fetcher end to end without
+// an external Vault server.
+//
+// It implements the KV version 2 secrets engine (data, metadata, list, delete,
+// undelete, destroy, check-and-set and versions), the KV version 1 secrets
+// engine, reading mount information, AppRole login, Kubernetes login and token
+// renewal. Every token may access every path, policies are not enforced.
+// Tokens of logins expire after Config.TokenTTL unless they are renewed.
+package vaulttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake vault listening on a local port, its fields mirror
+// test.VaultServer of github.com/WqyJh/consul-vault-conf.
+type Server struct {
+	VaultAddr     string
+	RootToken     string
+	AppRoleTokens map[string]AppRoleToken
+
+	server *httptest.Server
+
+	mu              sync.Mutex
+	mounts          map[string]*kvMount
+	appRoles        map[string]AppRoleToken
+	kubernetesRoles map[string]string
+	kubernetesMount string
+	tokenTTL        time.Duration
+	// tokens by id with when they expire, zero for never
+	tokens  map[string]time.Time
+	counter int
+}
+
+// AppRoleToken is the role id and secret id to login with an app role.
+type AppRoleToken struct {
+	RoleId   string
+	SecretId string
+}
+
+type Config struct {
+	// Mounts lists the KV version 2 mounts to enable, defaults to a single
+	// mount at "kv".
+	Mounts []string
+	// KVv1Mounts lists the KV version 1 mounts to enable.
+	KVv1Mounts []string
+	// AppRoles lists the names of the app roles to create.
+	AppRoles []string
+	// KubernetesRoles maps the roles of the Kubernetes auth method to the
+	// service account JWT each accepts.
+	KubernetesRoles map[string]string
+	// KubernetesMount is the mount of the Kubernetes auth method, defaults to
+	// "kubernetes".
+	KubernetesMount string
+	// TokenTTL is the lease of the tokens of logins, defaults to an hour.
+	// Tokens can be renewed by auth/token/renew-self for another TokenTTL.
+	TokenTTL time.Duration
+}
+
+// NewServer starts a fake vault, call Stop to shut it down.
+func NewServer(config Config) *Server {
+	s := &Server{
+		RootToken:     "root",
+		AppRoleTokens: make(map[string]AppRoleToken),
+		mounts:        make(map[string]*kvMount),
+		appRoles:      make(map[string]AppRoleToken),
+		tokens:        make(map[string]time.Time),
+		tokenTTL:      config.TokenTTL,
+	}
+	s.tokens[s.RootToken] = time.Time{}
+	if s.tokenTTL <= 0 {
+		s.tokenTTL = time.Hour
+	}
+
+	mounts := config.Mounts
+	if len(mounts) == 0 {
+		mounts = []string{"kv"}
+	}
+	for _, mount := range mounts {
+		s.mounts[strings.Trim(mount, "/")] = &kvMount{
+			version: 2,
+			entries: make(map[string]*kvEntry),
+		}
+	}
+	for _, mount := range config.KVv1Mounts {
+		s.mounts[strings.Trim(mount, "/")] = &kvMount{
+			version: 1,
+			entries: make(map[string]*kvEntry),
+		}
+	}
+
+	for _, name := range config.AppRoles {
+		token := AppRoleToken{
+			RoleId:   s.newId("role"),
+			SecretId: s.newId("secret"),
+		}
+		s.AppRoleTokens[name] = token
+		s.appRoles[token.RoleId] = token
+	}
+
+	s.kubernetesRoles = config.KubernetesRoles
+	s.kubernetesMount = strings.Trim(config.KubernetesMount, "/")
+	if s.kubernetesMount == "" {
+		s.kubernetesMount = "kubernetes"
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.VaultAddr = s.server.URL
+	return s
+}
+
+// Stop shuts the server down.
+func (s *Server) Stop() {
+	s.server.Close()
+}
+
+// ExpireTokens revokes the tokens of all logins, as if their leases ran out.
+// The root token stays valid.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token := range s.tokens {
+		if token != s.RootToken {
+			delete(s.tokens, token)
+		}
+	}
+}
+
+// validToken reports whether token exists and has not expired.
+func (s *Server) validToken(token string) bool {
+	expires, ok := s.tokens[token]
+	return ok && (expires.IsZero() || time.Now().Before(expires))
+}
+
+func (s *Server) newId(prefix string) string {
+	s.counter++
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), s.counter)
+}
+
+type kvMount struct {
+	version int
+	entries map[string]*kvEntry
+}
+
+type kvEntry struct {
+	// KV version 1 value
+	value map[string]interface{}
+
+	// KV version 2 metadata and versions
+	casRequired        bool
+	maxVersions        int64
+	deleteVersionAfter string
+	customMetadata     map[string]interface{}
+	createdTime        time.Time
+	updatedTime        time.Time
+	currentVersion     int64
+	oldestVersion      int64
+	versions           map[int64]*kvVersion
+}
+
+type kvVersion struct {
+	data         map[string]interface{}
+	createdTime  time.Time
+	deletionTime time.Time
+	destroyed    bool
+}
+
+func (v *kvVersion) deleted() bool {
+	return v.destroyed || !v.deletionTime.IsZero()
+}
+
+func (v *kvVersion) metadata(version int64) map[string]interface{} {
+	deletionTime := ""
+	if !v.deletionTime.IsZero() {
+		deletionTime = v.deletionTime.Format(time.RFC3339Nano)
+	}
+	return map[string]interface{}{
+		"created_time":  v.createdTime.Format(time.RFC3339Nano),
+		"deletion_time": deletionTime,
+		"destroyed":     v.destroyed,
+		"version":       version,
+	}
+}
+
+type vaultError struct {
+	status int
+	msg    string
+}
+
+func errorf(status int, format string, args ...interface{}) *vaultError {
+	return &vaultError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, data, err := s.route(r)
+	if err != nil {
+		writeJson(w, err.status, map[string]interface{}{"errors": []string{err.msg}})
+		return
+	}
+	if body == nil && data == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if body == nil {
+		body = map[string]interface{}{}
+	}
+	if data != nil {
+		body["data"] = data
+	}
+	writeJson(w, http.StatusOK, body)
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func readBody(r *http.Request, v interface{}) *vaultError {
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return errorf(http.StatusBadRequest, "failed to read body: %s", err)
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "failed to parse JSON input: %s", err)
+	}
+	return nil
+}
+
+// route dispatches the request, it returns the top level response body, the
+// value of its "data" field, or an error. Both nil means 204 No Content.
+func (s *Server) route(r *http.Request) (map[string]interface{}, interface{}, *vaultError) {
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if p == r.URL.Path {
+		return nil, nil, errorf(http.StatusNotFound, "unsupported path")
+	}
+
+	method := r.Method
+	if method == http.MethodGet && r.URL.Query().Get("list") == "true" {
+		method = "LIST"
+	}
+	if method == http.MethodPut {
+		method = http.MethodPost
+	}
+
+	if strings.HasPrefix(p, "auth/") {
+		return s.handleAuth(r, method, strings.TrimPrefix(p, "auth/"))
+	}
+
+	if !s.validToken(r.Header.Get("X-Vault-Token")) {
+		return nil, nil, errorf(http.StatusForbidden, "permission denied")
+	}
+
+	if strings.HasPrefix(p, "sys/") {
+		return s.handleSys(method, strings.TrimPrefix(p, "sys/"))
+	}
+
+	mountPath, mount, rest := s.findMount(p)
+	if mount == nil {
+		return nil, nil, errorf(http.StatusNotFound, "no handler for route %q", p)
+	}
+	if mount.version == 1 {
+		return s.handleKvV1(r, method, mount, rest)
+	}
+	return s.handleKvV2(r, method, mountPath, mount, rest)
+}
+
+func (s *Server) findMount(p string) (string, *kvMount, string) {
+	var found string
+	for mountPath := range s.mounts {
+		if p == mountPath || strings.HasPrefix(p, mountPath+"/") {
+			if len(mountPath) > len(found) {
+				found = mountPath
+			}
+		}
+	}
+	if found == "" {
+		return "", nil, ""
+	}
+	return found, s.mounts[found], strings.TrimPrefix(strings.TrimPrefix(p, found), "/")
+}
+
+// handleSys serves the mount information the vault cli reads to find the
+// version of a KV mount.
+func (s *Server) handleSys(method string, p string) (map[string]interface{}, interface{}, *vaultError) {
+	var mountPath string
+	switch {
+	case strings.HasPrefix(p, "internal/ui/mounts/"):
+		mountPath = strings.TrimPrefix(p, "internal/ui/mounts/")
+	case strings.HasPrefix(p, "mounts/"):
+		mountPath = strings.TrimPrefix(p, "mounts/")
+	default:
+		return nil, nil, errorf(http.StatusNotFound, "no handler for route %q", "sys/"+p)
+	}
+	if method != http.MethodGet {
+		return nil, nil, errorf(http.StatusMethodNotAllowed, "unsupported operation")
+	}
+
+	mountPath, mount, _ := s.findMount(strings.Trim(mountPath, "/"))
+	if mount == nil {
+		return nil, nil, errorf(http.StatusBadRequest, "no mount found")
+	}
+	var options map[string]interface{}
+	if mount.version == 2 {
+		options = map[string]interface{}{"version": "2"}
+	}
+	return nil, map[string]interface{}{
+		"type":    "kv",
+		"path":    mountPath + "/",
+		"options": options,
+	}, nil
+}
+
+func (s *Server) handleAuth(r *http.Request, method string, p string) (map[string]interface{}, interface{}, *vaultError) {
+	if method != http.MethodPost {
+		return nil, nil, errorf(http.StatusMethodNotAllowed, "unsupported operation")
+	}
+	switch p {
+	case "approle/login":
+		var request struct {
+			RoleId   string `json:"role_id"`
+			SecretId string `json:"secret_id"`
+		}
+		if err := readBody(r, &request); err != nil {
+			return nil, nil, err
+		}
+		token, ok := s.appRoles[request.RoleId]
+		if !ok || token.SecretId != request.SecretId {
+			return nil, nil, errorf(http.StatusBadRequest, "invalid role or secret ID")
+		}
+		return s.login(), nil, nil
+	case s.kubernetesMount + "/login":
+		var request struct {
+			Jwt  string `json:"jwt"`
+			Role string `json:"role"`
+		}
+		if err := readBody(r, &request); err != nil {
+			return nil, nil, err
+		}
+		jwt, ok := s.kubernetesRoles[request.Role]
+		if !ok {
+			return nil, nil, errorf(http.StatusBadRequest, "invalid role name %q", request.Role)
+		}
+		if request.Jwt == "" || jwt != request.Jwt {
+			return nil, nil, errorf(http.StatusForbidden, "permission denied")
+		}
+		return s.login(), nil, nil
+	case "token/renew-self":
+		token := r.Header.Get("X-Vault-Token")
+		if !s.validToken(token) {
+			return nil, nil, errorf(http.StatusForbidden, "permission denied")
+		}
+		if !s.tokens[token].IsZero() {
+			s.tokens[token] = time.Now().Add(s.tokenTTL)
+		}
+		return s.authResponse(token), nil, nil
+	}
+	return nil, nil, errorf(http.StatusNotFound, "no handler for route %q", "auth/"+p)
+}
+
+func (s *Server) login() map[string]interface{} {
+	token := s.newId("token")
+	s.tokens[token] = time.Now().Add(s.tokenTTL)
+	return s.authResponse(token)
+}
+
+func (s *Server) authResponse(token string) map[string]interface{} {
+	leaseDuration := 0
+	if !s.tokens[token].IsZero() {
+		leaseDuration = int(s.tokenTTL.Seconds())
+	}
+	// the "data" field must be present, the client parses the whole body as
+	// data otherwise
+	return map[string]interface{}{
+		"data": nil,
+		"auth": map[string]interface{}{
+			"client_token":   token,
+			"accessor":       s.newId("accessor"),
+			"policies":       []string{"default"},
+			"token_policies": []string{"default"},
+			"lease_duration": leaseDuration,
+			"renewable":      true,
+		},
+	}
+}
+
+func (s *Server) handleKvV2(r *http.Request, method string, mountPath string, mount *kvMount, p string) (map[string]interface{}, interface{}, *vaultError) {
+	op, key, _ := strings.Cut(p, "/")
+	key = strings.Trim(key, "/")
+
+	switch {
+	case op == "data" && method == http.MethodGet:
+		return s.kvV2Read(r, mount, key)
+	case op == "data" && method == http.MethodPost:
+		return s.kvV2Write(r, mount, key)
+	case op == "data" && method == http.MethodDelete:
+		return s.kvV2DeleteLatest(mount, key)
+	case op == "delete" && method == http.MethodPost:
+		return s.kvV2UpdateVersions(r, mount, key, func(v *kvVersion) {
+			if v.deletionTime.IsZero() {
+				v.deletionTime = time.Now().UTC()
+			}
+		})
+	case op == "undelete" && method == http.MethodPost:
+		return s.kvV2UpdateVersions(r, mount, key, func(v *kvVersion) {
+			if !v.destroyed {
+				v.deletionTime = time.Time{}
+			}
+		})
+	case op == "destroy" && method == http.MethodPost:
+		return s.kvV2UpdateVersions(r, mount, key, func(v *kvVersion) {
+			v.destroyed = true
+			v.data = nil
+		})
+	case op == "metadata" && method == "LIST":
+		return listKeys(mount, key)
+	case op == "metadata" && method == http.MethodGet:
+		return s.kvV2ReadMetadata(mount, key)
+	case op == "metadata" && method == http.MethodPost:
+		return s.kvV2WriteMetadata(r, mount, key)
+	case op == "metadata" && method == http.MethodDelete:
+		delete(mount.entries, key)
+		return nil, nil, nil
+	}
+	return nil, nil, errorf(http.StatusNotFound, "no handler for route %q", path.Join(mountPath, p))
+}
+
+func (s *Server) handleKvV1(r *http.Request, method string, mount *kvMount, key string) (map[string]interface{}, interface{}, *vaultError) {
+	key = strings.Trim(key, "/")
+	switch method {
+	case "LIST":
+		return listKeys(mount, key)
+	case http.MethodGet:
+		entry, ok := mount.entries[key]
+		if !ok {
+			return nil, nil, errorf(http.StatusNotFound, "")
+		}
+		return nil, entry.value, nil
+	case http.MethodPost:
+		var value map[string]interface{}
+		if err := readBody(r, &value); err != nil {
+			return nil, nil, err
+		}
+		mount.entries[key] = &kvEntry{value: value}
+		return nil, nil, nil
+	case http.MethodDelete:
+		delete(mount.entries, key)
+		return nil, nil, nil
+	}
+	return nil, nil, errorf(http.StatusMethodNotAllowed, "unsupported operation")
+}
+
+func (s *Server) kvV2Read(r *http.Request, mount *kvMount, key string) (map[string]interface{}, interface{}, *vaultError) {
+	entry, ok := mount.entries[key]
+	if !ok || entry.currentVersion == 0 {
+		return nil, nil, errorf(http.StatusNotFound, "")
+	}
+	version := entry.currentVersion
+	if v := r.URL.Query().Get("version"); v != "" && v != "0" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, nil, errorf(http.StatusBadRequest, "invalid version: %s", v)
+		}
+		version = parsed
+	}
+	kv, ok := entry.versions[version]
+	if !ok || kv.deleted() {
+		return nil, nil, errorf(http.StatusNotFound, "")
+	}
+	metadata := kv.metadata(version)
+	metadata["custom_metadata"] = entry.customMetadata
+	return nil, map[string]interface{}{
+		"data":     kv.data,
+		"metadata": metadata,
+	}, nil
+}
+
+func (s *Server) kvV2Write(r *http.Request, mount *kvMount, key string) (map[string]interface{}, interface{}, *vaultError) {
+	var request struct {
+		Data    map[string]interface{} `json:"data"`
+		Options map[string]interface{} `json:"options"`
+	}
+	if err := readBody(r, &request); err != nil {
+		return nil, nil, err
+	}
+	if request.Data == nil {
+		return nil, nil, errorf(http.StatusBadRequest, "no data provided")
+	}
+
+	entry, exists := mount.entries[key]
+	cas, hasCas := request.Options["cas"]
+	if hasCas {
+		expected, err := strconv.ParseInt(fmt.Sprint(cas), 10, 64)
+		if err != nil {
+			return nil, nil, errorf(http.StatusBadRequest, "invalid cas: %v", cas)
+		}
+		var current int64
+		if exists {
+			current = entry.currentVersion
+		}
+		if expected != current {
+			return nil, nil, errorf(http.StatusBadRequest, "check-and-set parameter did not match the current version")
+		}
+	} else if exists && entry.casRequired {
+		return nil, nil, errorf(http.StatusBadRequest, "check-and-set parameter required for this call")
+	}
+
+	now := time.Now().UTC()
+	if !exists {
+		entry = &kvEntry{
+			deleteVersionAfter: "0s",
+			createdTime:        now,
+			versions:           make(map[int64]*kvVersion),
+		}
+		mount.entries[key] = entry
+	}
+	entry.currentVersion++
+	entry.updatedTime = now
+	entry.versions[entry.currentVersion] = &kvVersion{
+		data:        request.Data,
+		createdTime: now,
+	}
+	if entry.oldestVersion == 0 {
+		entry.oldestVersion = entry.currentVersion
+	}
+	entry.prune()
+
+	metadata := entry.versions[entry.currentVersion].metadata(entry.currentVersion)
+	metadata["custom_metadata"] = entry.customMetadata
+	return nil, metadata, nil
+}
+
+// prune drops the oldest versions beyond max_versions, which defaults to 10.
+func (e *kvEntry) prune() {
+	maxVersions := e.maxVersions
+	if maxVersions <= 0 {
+		maxVersions = 10
+	}
+	for e.currentVersion-e.oldestVersion+1 > maxVersions {
+		delete(e.versions, e.oldestVersion)
+		e.oldestVersion++
+	}
+}
+
+func (s *Server) kvV2DeleteLatest(mount *kvMount, key string) (map[string]interface{}, interface{}, *vaultError) {
+	entry, ok := mount.entries[key]
+	if !ok {
+		return nil, nil, nil
+	}
+	if v, ok := entry.versions[entry.currentVersion]; ok && v.deletionTime.IsZero() {
+		v.deletionTime = time.Now().UTC()
+	}
+	return nil, nil, nil
+}
+
+func (s *Server) kvV2UpdateVersions(r *http.Request, mount *kvMount, key string, update func(v *kvVersion)) (map[string]interface{}, interface{}, *vaultError) {
+	var request struct {
+		Versions []json.Number `json:"versions"`
+	}
+	if err := readBody(r, &request); err != nil {
+		return nil, nil, err
+	}
+	if len(request.Versions) == 0 {
+		return nil, nil, errorf(http.StatusBadRequest, "no version number provided")
+	}
+	entry, ok := mount.entries[key]
+	if !ok {
+		return nil, nil, nil
+	}
+	for _, number := range request.Versions {
+		version, err := number.Int64()
+		if err != nil {
+			return nil, nil, errorf(http.StatusBadRequest, "invalid version: %s", number)
+		}
+		if v, ok := entry.versions[version]; ok {
+			update(v)
+		}
+	}
+	return nil, nil, nil
+}
+
+func (s *Server) kvV2ReadMetadata(mount *kvMount, key string) (map[string]interface{}, interface{}, *vaultError) {
+	entry, ok := mount.entries[key]
+	if !ok {
+		return nil, nil, errorf(http.StatusNotFound, "")
+	}
+	versions := make(map[string]interface{}, len(entry.versions))
+	for version, v := range entry.versions {
+		metadata := v.metadata(version)
+		delete(metadata, "version")
+		versions[strconv.FormatInt(version, 10)] = metadata
+	}
+	return nil, map[string]interface{}{
+		"cas_required":         entry.casRequired,
+		"created_time":         entry.createdTime.Format(time.RFC3339Nano),
+		"current_version":      entry.currentVersion,
+		"custom_metadata":      entry.customMetadata,
+		"delete_version_after": entry.deleteVersionAfter,
+		"max_versions":         entry.maxVersions,
+		"oldest_version":       entry.oldestVersion,
+		"updated_time":         entry.updatedTime.Format(time.RFC3339Nano),
+		"versions":             versions,
+	}, nil
+}
+
+func (s *Server) kvV2WriteMetadata(r *http.Request, mount *kvMount, key string) (map[string]interface{}, interface{}, *vaultError) {
+	var request struct {
+		CasRequired        *bool                  `json:"cas_required"`
+		MaxVersions        *int64                 `json:"max_versions"`
+		DeleteVersionAfter *string                `json:"delete_version_after"`
+		CustomMetadata     map[string]interface{} `json:"custom_metadata"`
+	}
+	if err := readBody(r, &request); err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	entry, ok := mount.entries[key]
+	if !ok {
+		entry = &kvEntry{
+			deleteVersionAfter: "0s",
+			createdTime:        now,
+			versions:           make(map[int64]*kvVersion),
+		}
+		mount.entries[key] = entry
+	}
+	if request.CasRequired != nil {
+		entry.casRequired = *request.CasRequired
+	}
+	if request.MaxVersions != nil {
+		entry.maxVersions = *request.MaxVersions
+	}
+	if request.DeleteVersionAfter != nil {
+		duration, err := time.ParseDuration(*request.DeleteVersionAfter)
+		if err != nil {
+			return nil, nil, errorf(http.StatusBadRequest, "invalid delete_version_after: %s", *request.DeleteVersionAfter)
+		}
+		entry.deleteVersionAfter = duration.String()
+	}
+	if len(request.CustomMetadata) > 0 {
+		entry.customMetadata = request.CustomMetadata
+	}
+	entry.updatedTime = now
+	if entry.currentVersion > 0 {
+		entry.prune()
+	}
+	return nil, nil, nil
+}
+
+func listKeys(mount *kvMount, prefix string) (map[string]interface{}, interface{}, *vaultError) {
+	if prefix != "" {
+		prefix += "/"
+	}
+	seen := make(map[string]bool)
+	for key := range mount.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if dir, _, ok := strings.Cut(rest, "/"); ok {
+			seen[dir+"/"] = true
+		} else {
+			seen[rest] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil, nil, errorf(http.StatusNotFound, "")
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return nil, map[string]interface{}{"keys": keys}, nil
+}
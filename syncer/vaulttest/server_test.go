@@ -0,0 +1,64 @@
+package vaulttest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/WqyJh/vaultsync/syncer/vaulttest"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppRoleLogin(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{
+		AppRoles: []string{"unittest"},
+	})
+	defer vaultServer.Stop()
+
+	token := vaultServer.AppRoleTokens["unittest"]
+	sync := syncer.NewSyncer(syncer.SyncerConfig{
+		VaultAddr:     vaultServer.VaultAddr,
+		VaultRoleId:   token.RoleId,
+		VaultSecretId: token.SecretId,
+		MountPath:     "kv",
+		VaultPath:     "unittest",
+		LocalPath:     "../../testdata/dir1",
+		CasTry:        3,
+	})
+	err := sync.Sync(ctx)
+	require.NoError(t, err)
+
+	sync = syncer.NewSyncer(syncer.SyncerConfig{
+		VaultAddr:     vaultServer.VaultAddr,
+		VaultRoleId:   token.RoleId,
+		VaultSecretId: "wrong",
+		MountPath:     "kv",
+		VaultPath:     "unittest",
+		LocalPath:     "../../testdata/dir1",
+		CasTry:        3,
+	})
+	err = sync.Sync(ctx)
+	require.Error(t, err)
+	require.True(t, vault.IsErrorStatus(err, 400))
+}
+
+func TestToken(t *testing.T) {
+	ctx := context.Background()
+	vaultServer := vaulttest.NewServer(vaulttest.Config{})
+	defer vaultServer.Stop()
+
+	client, err := vault.New(vault.WithAddress(vaultServer.VaultAddr))
+	require.NoError(t, err)
+	err = client.SetToken("wrong")
+	require.NoError(t, err)
+
+	_, err = client.Secrets.KvV2Read(ctx, "unittest/test1", vault.WithMountPath("kv"))
+	require.True(t, vault.IsErrorStatus(err, 403))
+
+	err = client.SetToken(vaultServer.RootToken)
+	require.NoError(t, err)
+	_, err = client.Secrets.KvV2Read(ctx, "unittest/test1", vault.WithMountPath("kv"))
+	require.True(t, vault.IsErrorStatus(err, 404))
+}
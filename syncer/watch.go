@@ -0,0 +1,252 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch syncs LocalPath to vault, then watches LocalPath and its directories
+// for changes until ctx is done. Changes are collected until no file changed
+// for debounce, then only the keys of the changed files are synced, keys whose
+// files were removed are deleted according to DeletePolicy. All keys are
+// synced instead after an IgnoreFile changed or the watcher failed, e.g. lost
+// events. A failed sync of changes is logged and retried with the next change.
+// A token got by logging in is renewed, or replaced by logging in again, so
+// Watch keeps working after its lease runs out.
+func (s *Syncer) Watch(ctx context.Context, debounce time.Duration) error {
+	backend, err := s.kvBackend(ctx)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Close()
+	_, err = watchDirs(watcher, s.LocalPath)
+	if err != nil {
+		return err
+	}
+
+	var desired DesiredState
+	err = s.auth.do(ctx, func() error {
+		desired, _, err = s.sync(ctx, backend)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("watching %s", s.LocalPath)
+
+	changed := make(map[string]bool)
+	// resync is set when the changed files can not tell which keys changed,
+	// then all keys are synced
+	resync := false
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			// e.g. the event queue overflowed and changes were lost
+			log.Printf("failed to watch, syncing all keys: %s, %+v", s.LocalPath, err)
+			resync = true
+			timer.Reset(debounce)
+		case event := <-watcher.Events:
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if filepath.Base(event.Name) == IgnoreFile {
+				resync = true
+			}
+			changed[event.Name] = true
+			if event.Op.Has(fsnotify.Create) {
+				// files created in a new directory before it is watched
+				files, err := watchDirs(watcher, event.Name)
+				if err != nil {
+					log.Printf("failed to watch directory: %s, %+v", event.Name, err)
+				}
+				for _, file := range files {
+					changed[file] = true
+				}
+			}
+			timer.Reset(debounce)
+		case <-timer.C:
+			var next DesiredState
+			err := s.auth.do(ctx, func() error {
+				var err error
+				if resync {
+					_, err = watchDirs(watcher, s.LocalPath)
+					if err == nil {
+						next, _, err = s.sync(ctx, backend)
+					}
+				} else {
+					next, err = s.syncChanged(ctx, backend, desired, changed)
+				}
+				return err
+			})
+			if err != nil {
+				log.Printf("failed to sync changes: %+v", err)
+				continue
+			}
+			desired = next
+			changed = make(map[string]bool)
+			resync = false
+		}
+	}
+}
+
+// watchDirs adds dir and its directories to watcher and returns the files in
+// them. It does nothing if dir is not a directory.
+func watchDirs(watcher *fsnotify.Watcher, dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, filePath)
+			return nil
+		}
+		err = watcher.Add(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to watch directory: %s, %w", filePath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// syncChanged syncs the keys of the changed files, previous is the desired
+// state of the last sync. Only the files of those keys are read. It returns
+// the current desired state.
+func (s *Syncer) syncChanged(ctx context.Context, backend KVBackend, previous DesiredState, changed map[string]bool) (DesiredState, error) {
+	policy, err := s.deletePolicy(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	changedKeys := make(map[string]bool)
+	// directories, or files that are not secret files
+	var prefixes []string
+	for file := range changed {
+		key, isDir := s.changedKey(file)
+		if isDir {
+			prefixes = append(prefixes, key+"/")
+		} else {
+			changedKeys[key] = true
+		}
+	}
+	isChanged := func(key string) bool {
+		if changedKeys[key] {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	loaded, err := s.desiredKeys(backend, isChanged)
+	if err != nil {
+		return nil, err
+	}
+	desired := make(DesiredState, len(previous)+len(loaded))
+	for key, secret := range previous {
+		if !isChanged(key) {
+			desired[key] = secret
+		}
+	}
+	for key, secret := range loaded {
+		desired[key] = secret
+	}
+
+	ignore, err := loadIgnore(s.LocalPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ignore files: %w", err)
+	}
+	affected := make(map[string]bool)
+	for key := range loaded {
+		affected[key] = true
+	}
+	for key := range previous {
+		if isChanged(key) {
+			affected[key] = true
+		}
+	}
+	var keys []string
+	for key := range affected {
+		if !ignore.ignoredKey(s.relativeKey(key)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	remotes := make([]*RemoteKV, len(keys))
+	err = forEach(ctx, len(keys), s.Concurrency, func(ctx context.Context, i int, logger *log.Logger) error {
+		remote, err := readRemoteKV(ctx, backend, keys[i])
+		if err != nil {
+			return fmt.Errorf("failed to read remote kv: %s, %w", keys[i], err)
+		}
+		if !remote.Exists && remote.Metadata == nil {
+			remote = nil
+		}
+		remotes[i] = remote
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []Action
+	for i, key := range keys {
+		actions = append(actions, s.planKey(key, desired[key], remotes[i], policy)...)
+	}
+	if deletes := countDeletes(actions); deletes > 0 {
+		total, err := s.countKeys(ctx, backend)
+		if err != nil {
+			return nil, err
+		}
+		err = s.checkDeletes(deletes, total)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = forEach(ctx, len(keys), s.Concurrency, func(ctx context.Context, i int, logger *log.Logger) error {
+		_, err := s.syncKey(ctx, backend, keys[i], desired[keys[i]], remotes[i], policy, logger)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync kv: %w", err)
+	}
+	return desired, nil
+}
+
+// changedKey returns the key of a changed secret or metadata file. For other
+// paths it returns the key prefix of the path and true.
+func (s *Syncer) changedKey(file string) (string, bool) {
+	if ext := secretExt(file); ext != "" {
+		return toVaultKey(s.LocalPath, file, s.VaultPath), false
+	}
+	codec, ext := lookupCodec(file)
+	if codec != nil && strings.HasSuffix(file, ".meta"+ext) {
+		return toVaultKey(s.LocalPath, strings.TrimSuffix(file, ".meta"+ext), s.VaultPath), false
+	}
+	return toVaultKey(s.LocalPath, file, s.VaultPath), true
+}
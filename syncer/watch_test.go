@@ -0,0 +1,150 @@
+package syncer_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/WqyJh/vaultsync/syncer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the syncer takes local paths relative to the working directory
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	localPath, err := filepath.Rel(wd, t.TempDir())
+	require.NoError(t, err)
+	writeFile := func(name, content string) {
+		err := os.WriteFile(filepath.Join(localPath, name), []byte(content), 0644)
+		require.NoError(t, err)
+	}
+	writeFile("config_1.json", `{"key1": "value1"}`)
+
+	backend := newMemBackend()
+	sync := syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath: "unittest",
+		LocalPath: localPath,
+		CasTry:    3,
+	}, backend)
+	done := make(chan error)
+	go func() {
+		done <- sync.Watch(ctx, 50*time.Millisecond)
+	}()
+
+	hasData := func(key string, data map[string]interface{}) func() bool {
+		return func() bool {
+			current, err := backend.ReadData(ctx, key)
+			if data == nil {
+				return err != nil
+			}
+			return err == nil && reflect.DeepEqual(data, current)
+		}
+	}
+	require.Eventually(t, hasData("unittest/config_1", map[string]interface{}{"key1": "value1"}), 5*time.Second, 10*time.Millisecond)
+
+	writeFile("config_1.json", `{"key1": "value2"}`)
+	writeFile("config_2.json", `{"key2": "value2"}`)
+	require.Eventually(t, hasData("unittest/config_1", map[string]interface{}{"key1": "value2"}), 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, hasData("unittest/config_2", map[string]interface{}{"key2": "value2"}), 5*time.Second, 10*time.Millisecond)
+
+	writeFile("config_2.meta.json", `{"custom_metadata": {"owner": "unittest"}}`)
+	require.Eventually(t, func() bool {
+		metadata, err := backend.ReadMetadata(ctx, "unittest/config_2")
+		return err == nil && reflect.DeepEqual(map[string]interface{}{"owner": "unittest"}, metadata.CustomMetadata)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	err = os.Remove(filepath.Join(localPath, "config_1.json"))
+	require.NoError(t, err)
+	require.Eventually(t, hasData("unittest/config_1", nil), 5*time.Second, 10*time.Millisecond)
+
+	err = os.MkdirAll(filepath.Join(localPath, "sub1/sub2"), 0755)
+	require.NoError(t, err)
+	writeFile("sub1/sub2/secret_1.json", `{"secret": "value"}`)
+	require.Eventually(t, hasData("unittest/sub1/sub2/secret_1", map[string]interface{}{"secret": "value"}), 5*time.Second, 10*time.Millisecond)
+
+	err = os.RemoveAll(filepath.Join(localPath, "sub1"))
+	require.NoError(t, err)
+	require.Eventually(t, hasData("unittest/sub1/sub2/secret_1", nil), 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, hasData("unittest/config_2", map[string]interface{}{"key2": "value2"}), time.Second, 10*time.Millisecond)
+
+	writeFile(".vaultsyncignore", "ignored.json\n")
+	writeFile("ignored.json", `{"key": "value"}`)
+	time.Sleep(200 * time.Millisecond)
+	require.True(t, hasData("unittest/ignored", nil)())
+	writeFile(".vaultsyncignore", "")
+	require.Eventually(t, hasData("unittest/ignored", map[string]interface{}{"key": "value"}), 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestWatchReadsChangedFiles(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	localPath, err := filepath.Rel(wd, t.TempDir())
+	require.NoError(t, err)
+	writeFile := func(name, content string) {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(localPath, name)), 0755)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(localPath, name), []byte(content), 0644)
+		require.NoError(t, err)
+	}
+	writeFile("config_1.json", `{"key1": "value1"}`)
+	writeFile("sub1/secret_1.json", `{"secret": "value1"}`)
+	writeFile("sub1/secret_2.json", `{"secret": "value2"}`)
+	// a file changed through a symlink outside of the watched directory
+	target := filepath.Join(t.TempDir(), "target.json")
+	err = os.WriteFile(target, []byte(`{"key2": "value2"}`), 0644)
+	require.NoError(t, err)
+	err = os.Symlink(target, filepath.Join(localPath, "config_2.json"))
+	require.NoError(t, err)
+
+	backend := newMemBackend()
+	sync := syncer.NewSyncerWithBackend(syncer.SyncerConfig{
+		VaultPath:  "unittest",
+		LocalPath:  localPath,
+		CasTry:     3,
+		MaxDeletes: 1,
+	}, backend)
+	done := make(chan error)
+	go func() {
+		done <- sync.Watch(ctx, 50*time.Millisecond)
+	}()
+
+	hasData := func(key string, data map[string]interface{}) func() bool {
+		return func() bool {
+			current, err := backend.ReadData(ctx, key)
+			if data == nil {
+				return err != nil
+			}
+			return err == nil && reflect.DeepEqual(data, current)
+		}
+	}
+	require.Eventually(t, hasData("unittest/config_2", map[string]interface{}{"key2": "value2"}), 5*time.Second, 10*time.Millisecond)
+
+	// a file that is not changed is not read
+	err = os.WriteFile(target, []byte(`not json`), 0644)
+	require.NoError(t, err)
+	writeFile("config_1.json", `{"key1": "value2"}`)
+	require.Eventually(t, hasData("unittest/config_1", map[string]interface{}{"key1": "value2"}), 5*time.Second, 10*time.Millisecond)
+
+	// a key already deleted in vault is not counted as a delete
+	err = backend.Delete(ctx, "unittest/sub1/secret_1")
+	require.NoError(t, err)
+	err = os.RemoveAll(filepath.Join(localPath, "sub1"))
+	require.NoError(t, err)
+	require.Eventually(t, hasData("unittest/sub1/secret_2", nil), 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}